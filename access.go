@@ -0,0 +1,94 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrAccessDenied = errors.New("sshd: access denied")
+
+// GroupsFunc 查询用户所属的组
+type GroupsFunc func(ctx context.Context, user string) ([]string, error)
+
+// AccessList 类似 OpenSSH 的 AllowUsers, DenyUsers, AllowGroups, DenyGroups,
+// 在任何认证方式执行前检查, 被拒绝的用户不会进入认证回调.
+//
+// 用户模式支持通配符 '*' 与 '?', 形如 "user@host" 时 host 部分与客户端 IP 匹配,
+// 可以是通配符或 CIDR, 如 "git@10.0.0.0/8", "admin@192.168.1.*".
+// 检查顺序为 DenyUsers, AllowUsers, DenyGroups, AllowGroups, Allow 列表非空时必须命中.
+type AccessList struct {
+	AllowUsers  []string
+	DenyUsers   []string
+	AllowGroups []string
+	DenyGroups  []string
+
+	// Groups 查询用户所属的组, AllowGroups 或 DenyGroups 非空时必须设置.
+	Groups GroupsFunc
+}
+
+// Check 检查 user 从 clientIP 登录是否被允许, 不允许时返回的错误包装了 ErrAccessDenied.
+func (al *AccessList) Check(ctx context.Context, user, clientIP string) error {
+	for _, pattern := range al.DenyUsers {
+		if matchUser(user, clientIP, pattern) {
+			return fmt.Errorf("%w: user %s from %s matches DenyUsers %q", ErrAccessDenied, user, clientIP, pattern)
+		}
+	}
+	if len(al.AllowUsers) > 0 && !matchAnyUser(user, clientIP, al.AllowUsers) {
+		return fmt.Errorf("%w: user %s from %s not listed in AllowUsers", ErrAccessDenied, user, clientIP)
+	}
+
+	if len(al.DenyGroups) == 0 && len(al.AllowGroups) == 0 {
+		return nil
+	}
+	if al.Groups == nil {
+		return fmt.Errorf("%w: no groups func for user %s", ErrAccessDenied, user)
+	}
+	groups, err := al.Groups(ctx, user)
+	if err != nil {
+		return fmt.Errorf("%w: lookup groups of user %s: %v", ErrAccessDenied, user, err)
+	}
+
+	for _, pattern := range al.DenyGroups {
+		if matchAnyGroup(groups, pattern) {
+			return fmt.Errorf("%w: user %s in DenyGroups %q", ErrAccessDenied, user, pattern)
+		}
+	}
+	if len(al.AllowGroups) > 0 {
+		for _, pattern := range al.AllowGroups {
+			if matchAnyGroup(groups, pattern) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: user %s not in AllowGroups", ErrAccessDenied, user)
+	}
+	return nil
+}
+
+func matchAnyUser(user, clientIP string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchUser(user, clientIP, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchUser 匹配 "user" 或 "user@host" 形式的模式
+func matchUser(user, clientIP, pattern string) bool {
+	userPattern, hostPattern, hasHost := strings.Cut(pattern, "@")
+	if !matchPattern(user, userPattern) {
+		return false
+	}
+	return !hasHost || matchAddr(clientIP, hostPattern)
+}
+
+func matchAnyGroup(groups []string, pattern string) bool {
+	for _, group := range groups {
+		if matchPattern(group, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAccessListCheck(t *testing.T) {
+	groups := func(ctx context.Context, user string) ([]string, error) {
+		switch user {
+		case "alice":
+			return []string{"wheel", "dev"}, nil
+		case "broken":
+			return nil, errors.New("directory unavailable")
+		}
+		return nil, nil
+	}
+
+	cases := []struct {
+		name    string
+		al      AccessList
+		user    string
+		ip      string
+		allowed bool
+	}{
+		{"empty list", AccessList{}, "alice", "10.1.2.3", true},
+		{"allow user", AccessList{AllowUsers: []string{"alice"}}, "alice", "10.1.2.3", true},
+		{"not in allow users", AccessList{AllowUsers: []string{"alice"}}, "bob", "10.1.2.3", false},
+		{"allow wildcard", AccessList{AllowUsers: []string{"a*"}}, "alice", "10.1.2.3", true},
+		{"user at cidr", AccessList{AllowUsers: []string{"git@10.0.0.0/8"}}, "git", "10.1.2.3", true},
+		{"user outside cidr", AccessList{AllowUsers: []string{"git@10.0.0.0/8"}}, "git", "192.168.1.7", false},
+		{"user at ip wildcard", AccessList{AllowUsers: []string{"admin@192.168.1.*"}}, "admin", "192.168.1.7", true},
+		{"other user at cidr", AccessList{AllowUsers: []string{"git@10.0.0.0/8"}}, "alice", "10.1.2.3", false},
+		{"deny before allow", AccessList{AllowUsers: []string{"*"}, DenyUsers: []string{"root"}}, "root", "10.1.2.3", false},
+		{"deny user at cidr", AccessList{DenyUsers: []string{"*@192.168.0.0/16"}}, "alice", "192.168.1.7", false},
+		{"deny user other cidr", AccessList{DenyUsers: []string{"*@192.168.0.0/16"}}, "alice", "10.1.2.3", true},
+		{"allow group", AccessList{AllowGroups: []string{"wheel"}, Groups: groups}, "alice", "10.1.2.3", true},
+		{"not in allow groups", AccessList{AllowGroups: []string{"wheel"}, Groups: groups}, "bob", "10.1.2.3", false},
+		{"deny group", AccessList{DenyGroups: []string{"d*"}, Groups: groups}, "alice", "10.1.2.3", false},
+		{"deny group before allow group", AccessList{AllowGroups: []string{"wheel"}, DenyGroups: []string{"dev"}, Groups: groups}, "alice", "10.1.2.3", false},
+		{"groups lookup error", AccessList{AllowGroups: []string{"wheel"}, Groups: groups}, "broken", "10.1.2.3", false},
+		{"nil groups func", AccessList{AllowGroups: []string{"wheel"}}, "alice", "10.1.2.3", false},
+		{"nil groups func with deny groups", AccessList{DenyGroups: []string{"wheel"}}, "bob", "10.1.2.3", false},
+		{"nil groups func without group lists", AccessList{AllowUsers: []string{"alice"}}, "alice", "10.1.2.3", true},
+		{"user without groups", AccessList{DenyGroups: []string{"wheel"}, Groups: groups}, "bob", "10.1.2.3", true},
+	}
+	for _, c := range cases {
+		err := c.al.Check(context.Background(), c.user, c.ip)
+		if c.allowed != (err == nil) {
+			t.Errorf("%s: user %s from %s: got %v, want allowed %v", c.name, c.user, c.ip, err, c.allowed)
+		}
+		if err != nil && !errors.Is(err, ErrAccessDenied) {
+			t.Errorf("%s: error %v does not wrap ErrAccessDenied", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// 认证方式, 与 ssh.ServerConfig.AuthLogCallback 中的 method 一致.
+const (
+	AuthMethodNone                = "none"
+	AuthMethodPublicKey           = "publickey"
+	AuthMethodPassword            = "password"
+	AuthMethodKeyboardInteractive = "keyboard-interactive"
+)
+
+// ErrAuthNotSupported Authenticator 不支持该认证方式, AuthChain 将尝试下一个.
+var ErrAuthNotSupported = errors.New("sshd: auth method not supported")
+
+// DefaultAuthenticator 全局的认证方式, 由 PublicKeyAuth 等函数设置.
+// 当 Server 未设置 Authenticator 时使用, 且仅补充 ssh.ServerConfig 中未设置的回调.
+var DefaultAuthenticator = &AuthFuncs{}
+
+// Authenticator 认证器, 每种认证方式对应一个方法, ctx 来源于 ConnContext.
+// 不支持的认证方式应返回 ErrAuthNotSupported.
+type Authenticator interface {
+	PublicKey(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
+	Password(ctx context.Context, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error)
+	KeyboardInteractive(ctx context.Context, conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error)
+}
+
+// AuthMethodChecker 可选接口, Authenticator 实现后可声明支持的认证方式.
+// 不支持的认证方式不会设置到 ssh.ServerConfig 上, 也就不会向客户端公布.
+type AuthMethodChecker interface {
+	SupportsAuthMethod(method string) bool
+}
+
+// SupportsAuthMethod 判断 auth 是否支持 method, 未实现 AuthMethodChecker 则视为支持.
+func SupportsAuthMethod(auth Authenticator, method string) bool {
+	if auth == nil {
+		return false
+	}
+	if checker, ok := auth.(AuthMethodChecker); ok {
+		return checker.SupportsAuthMethod(method)
+	}
+	return true
+}
+
+// 可获取连接 context 的认证回调, ctx 来源于 ConnContext, 如 uuid, PROXY protocol 源数据等.
+type (
+	// PublicKeyCallback 公钥认证回调, 如果认证不通过, error 应返回非 nil.
+	PublicKeyCallback func(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
+
+	// PasswordCallback 密码认证回调
+	PasswordCallback func(ctx context.Context, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error)
+
+	// KeyboardInteractiveCallback 交互式认证回调
+	KeyboardInteractiveCallback func(ctx context.Context, conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error)
+)
+
+// AuthFuncs 由函数组成的 Authenticator, 为 nil 的函数即不支持对应的认证方式.
+type AuthFuncs struct {
+	PublicKeyCallback           PublicKeyCallback
+	PasswordCallback            PasswordCallback
+	KeyboardInteractiveCallback KeyboardInteractiveCallback
+}
+
+var (
+	_ Authenticator     = &AuthFuncs{}
+	_ AuthMethodChecker = &AuthFuncs{}
+)
+
+// PublicKey implements Authenticator.PublicKey
+func (af *AuthFuncs) PublicKey(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if af.PublicKeyCallback == nil {
+		return nil, ErrAuthNotSupported
+	}
+	return af.PublicKeyCallback(ctx, conn, key)
+}
+
+// Password implements Authenticator.Password
+func (af *AuthFuncs) Password(ctx context.Context, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if af.PasswordCallback == nil {
+		return nil, ErrAuthNotSupported
+	}
+	return af.PasswordCallback(ctx, conn, password)
+}
+
+// KeyboardInteractive implements Authenticator.KeyboardInteractive
+func (af *AuthFuncs) KeyboardInteractive(ctx context.Context, conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	if af.KeyboardInteractiveCallback == nil {
+		return nil, ErrAuthNotSupported
+	}
+	return af.KeyboardInteractiveCallback(ctx, conn, client)
+}
+
+// SupportsAuthMethod implements AuthMethodChecker
+func (af *AuthFuncs) SupportsAuthMethod(method string) bool {
+	switch method {
+	case AuthMethodPublicKey:
+		return af.PublicKeyCallback != nil
+	case AuthMethodPassword:
+		return af.PasswordCallback != nil
+	case AuthMethodKeyboardInteractive:
+		return af.KeyboardInteractiveCallback != nil
+	}
+	return false
+}
+
+// AuthChain 按顺序尝试每个 Authenticator, 任一认证通过即返回.
+// 返回 ErrAuthNotSupported 的 Authenticator 将被跳过, 全部失败时返回最后一个错误.
+type AuthChain []Authenticator
+
+var (
+	_ Authenticator     = AuthChain{}
+	_ AuthMethodChecker = AuthChain{}
+)
+
+// NewAuthChain 创建 AuthChain, 忽略为 nil 的 Authenticator.
+func NewAuthChain(auths ...Authenticator) AuthChain {
+	chain := make(AuthChain, 0, len(auths))
+	for _, auth := range auths {
+		if auth != nil {
+			chain = append(chain, auth)
+		}
+	}
+	return chain
+}
+
+// PublicKey implements Authenticator.PublicKey
+func (chain AuthChain) PublicKey(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	return chain.try(AuthMethodPublicKey, func(auth Authenticator) (*ssh.Permissions, error) {
+		return auth.PublicKey(ctx, conn, key)
+	})
+}
+
+// Password implements Authenticator.Password
+func (chain AuthChain) Password(ctx context.Context, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	return chain.try(AuthMethodPassword, func(auth Authenticator) (*ssh.Permissions, error) {
+		return auth.Password(ctx, conn, password)
+	})
+}
+
+// KeyboardInteractive implements Authenticator.KeyboardInteractive
+func (chain AuthChain) KeyboardInteractive(ctx context.Context, conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return chain.try(AuthMethodKeyboardInteractive, func(auth Authenticator) (*ssh.Permissions, error) {
+		return auth.KeyboardInteractive(ctx, conn, client)
+	})
+}
+
+// SupportsAuthMethod implements AuthMethodChecker
+func (chain AuthChain) SupportsAuthMethod(method string) bool {
+	for _, auth := range chain {
+		if SupportsAuthMethod(auth, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (chain AuthChain) try(method string, fn func(auth Authenticator) (*ssh.Permissions, error)) (*ssh.Permissions, error) {
+	var lastErr = ErrAuthNotSupported
+	for _, auth := range chain {
+		if !SupportsAuthMethod(auth, method) {
+			continue
+		}
+
+		perms, err := fn(auth)
+		if err == nil {
+			return perms, nil
+		}
+		if !errors.Is(err, ErrAuthNotSupported) {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// bindAuthenticator 复制 conf, 并将 auth 支持的认证方式绑定到 ctx 后设置到副本上.
+// onlyUnset 为 true 时, 仅设置 conf 中为 nil 的回调.
+func bindAuthenticator(ctx context.Context, conf *ssh.ServerConfig, auth Authenticator, onlyUnset bool) *ssh.ServerConfig {
+	if conf == nil || auth == nil {
+		return conf
+	}
+
+	newConf := *conf
+	if SupportsAuthMethod(auth, AuthMethodPublicKey) && (!onlyUnset || newConf.PublicKeyCallback == nil) {
+		newConf.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return auth.PublicKey(ctx, conn, key)
+		}
+	}
+	if SupportsAuthMethod(auth, AuthMethodPassword) && (!onlyUnset || newConf.PasswordCallback == nil) {
+		newConf.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return auth.Password(ctx, conn, password)
+		}
+	}
+	if SupportsAuthMethod(auth, AuthMethodKeyboardInteractive) && (!onlyUnset || newConf.KeyboardInteractiveCallback == nil) {
+		newConf.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			return auth.KeyboardInteractive(ctx, conn, client)
+		}
+	}
+	return &newConf
+}
@@ -0,0 +1,262 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// connAuth 单个连接的认证状态, 包装 ssh.ServerConfig 的认证回调, 用于审计等.
+type connAuth struct {
+	srv *Server
+	ctx context.Context
+
+	mut           sync.Mutex
+	successMethod string
+	lastUser      string
+	failures      int
+	// checked 已通过前置检查的用户, 客户端可在认证过程中更换用户名
+	checked map[string]error
+	// methods 实际配置的认证方式, 仅其失败计入 AccountLockout
+	methods map[string]bool
+}
+
+func (srv *Server) newConnAuth(ctx context.Context) *connAuth {
+	return &connAuth{srv: srv, ctx: ctx}
+}
+
+// wrap 复制 conf, 并包装其中的认证回调
+func (ca *connAuth) wrap(conf *ssh.ServerConfig) *ssh.ServerConfig {
+	if conf == nil {
+		return nil
+	}
+	newConf := *conf
+	ca.methods = offeredAuthMethods(conf)
+
+	if fn := conf.PublicKeyCallback; fn != nil {
+		newConf.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if err := ca.checkKey(conn, key); err != nil {
+				return nil, &publicKeyError{fingerprint: ssh.FingerprintSHA256(key), err: err}
+			}
+			if err := ca.precheck(conn); err != nil {
+				return nil, &publicKeyError{fingerprint: ssh.FingerprintSHA256(key), err: err}
+			}
+			return ca.publicKey(conn, key, fn)
+		}
+	}
+	if fn := conf.PasswordCallback; fn != nil {
+		newConf.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if err := ca.precheck(conn); err != nil {
+				return nil, err
+			}
+			return fn(conn, password)
+		}
+	}
+	if fn := conf.KeyboardInteractiveCallback; fn != nil {
+		newConf.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			if err := ca.precheck(conn); err != nil {
+				return nil, err
+			}
+			return fn(conn, client)
+		}
+	}
+	if conf.NoClientAuth {
+		fn := conf.NoClientAuthCallback
+		newConf.NoClientAuthCallback = func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			if err := ca.precheck(conn); err != nil {
+				return nil, err
+			}
+			if fn == nil {
+				return nil, nil
+			}
+			return fn(conn)
+		}
+	}
+
+	logFn := conf.AuthLogCallback
+	newConf.AuthLogCallback = func(conn ssh.ConnMetadata, method string, err error) {
+		if logFn != nil {
+			logFn(conn, method, err)
+		}
+		ca.authLog(conn, method, err)
+	}
+	return &newConf
+}
+
+// offeredAuthMethods conf 实际提供的认证方式. 对未配置的认证方式,
+// golang.org/x/crypto/ssh 同样调用 AuthLogCallback, 如 "password auth not configured".
+func offeredAuthMethods(conf *ssh.ServerConfig) map[string]bool {
+	return map[string]bool{
+		AuthMethodNone:                conf.NoClientAuth,
+		AuthMethodPublicKey:           conf.PublicKeyCallback != nil,
+		AuthMethodPassword:            conf.PasswordCallback != nil,
+		AuthMethodKeyboardInteractive: conf.KeyboardInteractiveCallback != nil,
+		"gssapi-with-mic":             conf.GSSAPIWithMICConfig != nil,
+	}
+}
+
+// checkKey 在公钥认证回调执行前检查公钥, 如 KeyBlocklist.
+func (ca *connAuth) checkKey(conn ssh.ConnMetadata, key ssh.PublicKey) error {
+	if bl := ca.srv.KeyBlocklist; bl != nil && bl.Contains(key) {
+		ca.srv.logf("sshd: blocked key %s presented by %s from %s",
+			ssh.FingerprintSHA256(key), conn.User(), conn.RemoteAddr())
+		return ErrKeyBlocked
+	}
+	return nil
+}
+
+// precheck 在认证回调执行前检查, 如 AccountLockout, AccessList.
+func (ca *connAuth) precheck(conn ssh.ConnMetadata) error {
+	user := conn.User()
+	if lockout := ca.srv.AccountLockout; lockout != nil {
+		if err := lockout.Check(user); err != nil {
+			return err
+		}
+	}
+	return ca.checkAccess(conn)
+}
+
+// checkAccess 检查 AccessList 与 AccessSchedule, 每个用户名仅检查一次.
+func (ca *connAuth) checkAccess(conn ssh.ConnMetadata) error {
+	user := conn.User()
+	ca.mut.Lock()
+	err, ok := ca.checked[user]
+	ca.mut.Unlock()
+	if ok {
+		return err
+	}
+
+	if al := ca.srv.AccessList(); al != nil {
+		err = al.Check(ca.ctx, user, addrIP(conn.RemoteAddr()))
+	}
+	if as := ca.srv.AccessSchedule; err == nil && as != nil {
+		_, err = as.Check(ca.ctx, user, time.Now())
+	}
+	if err != nil {
+		ca.srv.logf("%v", err)
+	}
+
+	ca.mut.Lock()
+	if ca.checked == nil {
+		ca.checked = make(map[string]error)
+	}
+	ca.checked[user] = err
+	ca.mut.Unlock()
+	return err
+}
+
+func (ca *connAuth) publicKey(conn ssh.ConnMetadata, key ssh.PublicKey,
+	fn func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error)) (*ssh.Permissions, error) {
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	perms, err := fn(conn, key)
+	if err != nil {
+		return nil, &publicKeyError{fingerprint: fingerprint, err: err}
+	}
+
+	// 复制一份, 避免修改回调返回的共享 Permissions
+	newPerms := &ssh.Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      make(map[string]string),
+	}
+	if perms != nil {
+		for k, v := range perms.CriticalOptions {
+			newPerms.CriticalOptions[k] = v
+		}
+		for k, v := range perms.Extensions {
+			newPerms.Extensions[k] = v
+		}
+	}
+	newPerms.Extensions[PermExtFingerprint] = fingerprint
+	if cert, ok := key.(*ssh.Certificate); ok {
+		newPerms.Extensions[PermExtCertKeyID] = cert.KeyId
+	}
+	return newPerms, nil
+}
+
+func (ca *connAuth) authLog(conn ssh.ConnMetadata, method string, err error) {
+	ca.mut.Lock()
+	ca.lastUser = conn.User()
+	// 与 golang.org/x/crypto/ssh 一致, 首次的 "none" 不计入失败次数
+	if err != nil && (method != AuthMethodNone || ca.failures > 0) {
+		ca.failures++
+	}
+	ca.mut.Unlock()
+
+	if err != nil && ca.srv.AccountLockout != nil {
+		ca.recordFailure(conn.User(), method, err)
+	}
+
+	if err == nil {
+		// 认证成功的事件在握手完成后发出, 此时才能获取到最终的 Permissions
+		ca.mut.Lock()
+		ca.successMethod = method
+		ca.mut.Unlock()
+		return
+	}
+	ca.emit(newAuthEvent(conn, method, err))
+}
+
+// recordFailure 记录账户的认证失败, 未配置的认证方式与被 precheck 拒绝的不计入,
+// 否则仅支持公钥认证的服务器上, 任何人都可以通过密码认证请求锁定账户.
+func (ca *connAuth) recordFailure(user, method string, err error) {
+	lockout := ca.srv.AccountLockout
+	ca.mut.Lock()
+	offered := ca.methods[method]
+	ca.mut.Unlock()
+	if !offered || !lockout.CountsMethod(method) || errors.Is(err, ErrAccountLocked) || errors.Is(err, ErrAccessDenied) ||
+		errors.Is(err, ErrOutsideAccessWindow) {
+		return
+	}
+
+	locked, err := lockout.Fail(user)
+	if err != nil {
+		ca.srv.logf("sshd: record auth failure of %s: %v", user, err)
+		return
+	}
+	if locked {
+		ca.srv.logf("sshd: account %s locked for %s after %d failures", user, lockout.Cooldown, lockout.Threshold)
+	}
+}
+
+// authFailures 认证失败的次数
+func (ca *connAuth) authFailures() int {
+	ca.mut.Lock()
+	defer ca.mut.Unlock()
+	return ca.failures
+}
+
+// user 最近一次认证尝试的用户名
+func (ca *connAuth) user() string {
+	ca.mut.Lock()
+	defer ca.mut.Unlock()
+	return ca.lastUser
+}
+
+// authenticated 握手完成后调用
+func (ca *connAuth) authenticated(conn *ssh.ServerConn) {
+	ca.mut.Lock()
+	method := ca.successMethod
+	ca.mut.Unlock()
+
+	if lockout := ca.srv.AccountLockout; lockout != nil {
+		if err := lockout.Succeed(conn.User()); err != nil {
+			ca.srv.logf("sshd: reset auth failures of %s: %v", conn.User(), err)
+		}
+	}
+
+	event := newAuthEvent(conn, method, nil)
+	if conn.Permissions != nil {
+		event.Fingerprint = conn.Permissions.Extensions[PermExtFingerprint]
+	}
+	ca.emit(event)
+}
+
+func (ca *connAuth) emit(event *AuthEvent) {
+	if ca.srv.AuthEventCallback != nil {
+		ca.srv.AuthEventCallback(ca.ctx, event)
+	}
+}
@@ -0,0 +1,73 @@
+package sshd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PermExtFingerprint 公钥认证通过后, 公钥的 SHA256 指纹存放于 ssh.Permissions.Extensions 的 key.
+const PermExtFingerprint = "sshd-pubkey-fingerprint"
+
+// AuthEventCallback 每次认证尝试后调用, 包括客户端首次探测的 "none".
+// 在认证流程中同步调用, 不应阻塞.
+type AuthEventCallback func(ctx context.Context, event *AuthEvent)
+
+// AuthEvent 认证尝试的审计记录
+type AuthEvent struct {
+	Time   time.Time
+	Method string
+	User   string
+
+	// Fingerprint 公钥的 SHA256 指纹, 仅 publickey 认证有值.
+	Fingerprint string
+
+	// ClientIP 客户端 IP, 经 ConnCallback 处理后的连接地址, 如 PROXY protocol 中的真实 IP.
+	ClientIP      string
+	ClientVersion string
+	SessionID     string
+
+	Success bool
+	// Err 认证失败的原因, Reason 为其文本描述.
+	Err    error
+	Reason string
+}
+
+func newAuthEvent(conn ssh.ConnMetadata, method string, err error) *AuthEvent {
+	event := &AuthEvent{
+		Time:          time.Now(),
+		Method:        method,
+		User:          conn.User(),
+		ClientIP:      addrIP(conn.RemoteAddr()),
+		ClientVersion: string(conn.ClientVersion()),
+		SessionID:     hex.EncodeToString(conn.SessionID()),
+		Success:       err == nil,
+		Err:           err,
+	}
+	if err != nil {
+		event.Reason = err.Error()
+	}
+
+	var pkErr *publicKeyError
+	if errors.As(err, &pkErr) {
+		event.Fingerprint = pkErr.fingerprint
+	}
+	return event
+}
+
+// publicKeyError 携带公钥指纹的认证错误, 以便在 AuthLogCallback 中获取指纹.
+type publicKeyError struct {
+	fingerprint string
+	err         error
+}
+
+func (e *publicKeyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *publicKeyError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,107 @@
+package sshd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrKeyBlocked = errors.New("sshd: public key is blocked")
+
+// KeyBlocklist 已泄露或已知不安全的公钥列表, 如 Debian 弱密钥.
+// 文件每行为一个 SHA256 指纹 (如 "SHA256:...") 或 authorized_keys 格式的公钥, 以 # 开头的行为注释.
+// 每次检查前检查文件是否变化, 变化后自动重新加载.
+type KeyBlocklist struct {
+	// ErrLogger 输出重新加载时的错误, 默认为 log.Default, 可设置为 Server.ErrLogger
+	ErrLogger *log.Logger
+
+	reloader *fileReloader
+
+	mut          sync.RWMutex
+	fingerprints map[string]struct{}
+}
+
+// NewKeyBlocklist 读取并解析黑名单文件
+func NewKeyBlocklist(filename string) (*KeyBlocklist, error) {
+	kb := &KeyBlocklist{}
+	kb.reloader = newFileReloader("key blocklist", filename, kb.load)
+	if err := kb.Reload(); err != nil {
+		return nil, err
+	}
+	return kb, nil
+}
+
+// Reload 重新读取黑名单文件
+func (kb *KeyBlocklist) Reload() error {
+	return kb.reloader.reload()
+}
+
+func (kb *KeyBlocklist) load(data []byte) error {
+	fingerprints, err := parseKeyBlocklist(data)
+	if err != nil {
+		return err
+	}
+	kb.mut.Lock()
+	kb.fingerprints = fingerprints
+	kb.mut.Unlock()
+	return nil
+}
+
+// Contains 判断公钥是否在黑名单中, 证书同时检查其签发的公钥.
+func (kb *KeyBlocklist) Contains(key ssh.PublicKey) bool {
+	kb.reloader.reloadIfChanged(kb.ErrLogger)
+
+	kb.mut.RLock()
+	defer kb.mut.RUnlock()
+
+	if _, ok := kb.fingerprints[ssh.FingerprintSHA256(key)]; ok {
+		return true
+	}
+	if cert, ok := key.(*ssh.Certificate); ok {
+		if _, ok := kb.fingerprints[ssh.FingerprintSHA256(cert.Key)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Check 公钥在黑名单中时返回 ErrKeyBlocked
+func (kb *KeyBlocklist) Check(key ssh.PublicKey) error {
+	if kb.Contains(key) {
+		return ErrKeyBlocked
+	}
+	return nil
+}
+
+func parseKeyBlocklist(data []byte) (map[string]struct{}, error) {
+	fingerprints := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		if strings.HasPrefix(line, "SHA256:") {
+			fingerprints[strings.Fields(line)[0]] = struct{}{}
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		fingerprints[ssh.FingerprintSHA256(key)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return fingerprints, nil
+}
@@ -0,0 +1,142 @@
+package sshd
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultCertValidity   = time.Hour * 8
+	maxCertRequestSize    = 16 << 10
+	certValidAfterSkew    = time.Minute * 5
+	defaultCertSignerName = "sshd-ca"
+)
+
+var ErrCertRequestDenied = errors.New("sshd: certificate request denied")
+
+// CertTemplate 由 CertPolicy 决定的证书内容
+type CertTemplate struct {
+	KeyID      string
+	Principals []string
+
+	// Validity 证书有效期, 为 0 时为 DefaultCertValidity. ValidBefore 非零时优先.
+	Validity    time.Duration
+	ValidBefore time.Time
+
+	CriticalOptions map[string]string
+	// Extensions 为 nil 时使用 OpenSSH 的默认扩展 (permit-pty 等)
+	Extensions map[string]string
+}
+
+// CertPolicy 根据已认证的用户 (cc.User, cc.Permissions, cc.Principal) 与待签名的公钥决定证书内容,
+// 返回 error 则拒绝签发.
+type CertPolicy func(cc *ChannelChain, key ssh.PublicKey) (*CertTemplate, error)
+
+// CertSigner 作为轻量的 SSH CA, 读取标准输入中的用户公钥 (authorized_keys 格式),
+// 按 Policy 签发短期的用户证书并写入标准输出. 用法如:
+//
+//	ssh ca@host sign < ~/.ssh/id_ed25519.pub > ~/.ssh/id_ed25519-cert.pub
+type CertSigner struct {
+	CA     ssh.Signer
+	Policy CertPolicy
+}
+
+var _ CommandHandler = &CertSigner{}
+
+// NewCertSigner 创建 CertSigner
+func NewCertSigner(ca ssh.Signer, policy CertPolicy) *CertSigner {
+	return &CertSigner{CA: ca, Policy: policy}
+}
+
+// Execute implements CommandHandler
+func (cs *CertSigner) Execute(cc *ChannelChain) error {
+	cert, err := cs.sign(cc)
+	if err != nil {
+		fmt.Fprintf(cc.Stderr(), "%s: %v\n", defaultCertSignerName, err)
+		if exitErr := cc.Exit(1); exitErr != nil {
+			return exitErr
+		}
+		return err
+	}
+
+	if _, err := cc.Stdout().Write(ssh.MarshalAuthorizedKey(cert)); err != nil {
+		return err
+	}
+	return cc.Exit(0)
+}
+
+func (cs *CertSigner) sign(cc *ChannelChain) (*ssh.Certificate, error) {
+	if cs.CA == nil || cs.Policy == nil {
+		return nil, errors.New("sshd: certificate signer is not configured")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(cc.Stdin(), maxCertRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCertRequestSize {
+		return nil, errors.New("public key too large")
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return nil, errors.New("a certificate cannot be signed again")
+	}
+
+	tmpl, err := cs.Policy(cc, key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCertRequestDenied, err)
+	}
+	if tmpl == nil || len(tmpl.Principals) == 0 {
+		return nil, fmt.Errorf("%w: no principals", ErrCertRequestDenied)
+	}
+
+	now := time.Now()
+	validBefore := tmpl.ValidBefore
+	if validBefore.IsZero() {
+		validity := tmpl.Validity
+		if validity <= 0 {
+			validity = DefaultCertValidity
+		}
+		validBefore = now.Add(validity)
+	}
+
+	extensions := tmpl.Extensions
+	if extensions == nil {
+		extensions = make(map[string]string, len(defaultPermitExtensions))
+		for _, ext := range defaultPermitExtensions {
+			extensions[ext] = ""
+		}
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, err
+	}
+
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           tmpl.KeyID,
+		ValidPrincipals: tmpl.Principals,
+		ValidAfter:      uint64(now.Add(-certValidAfterSkew).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: tmpl.CriticalOptions,
+			Extensions:      extensions,
+		},
+	}
+	if err := cert.SignCert(rand.Reader, cs.CA); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
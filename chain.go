@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"net"
+	"time"
 
 	"github.com/anmitsu/go-shlex"
 	"golang.org/x/crypto/ssh"
@@ -51,6 +53,9 @@ type ChannelChain struct {
 
 	AcceptedEnvs map[string]string
 	RawCommand   string
+
+	principal *Principal
+	listener  *Listener
 }
 
 type (
@@ -93,6 +98,10 @@ func (cc *ChannelChain) entry(ctx context.Context, conn *ssh.ServerConn, newChan
 		newChannel.Reject(ssh.Prohibited, "prohibited any channel types")
 		return errors.New("prohibited any channel types")
 	}
+	if ext, ok := channelPermits[newChannel.ChannelType()]; ok && !cc.Permit(ext) {
+		newChannel.Reject(ssh.Prohibited, newChannel.ChannelType()+" not permitted")
+		return fmt.Errorf("channel type %s not permitted for %s", newChannel.ChannelType(), conn.User())
+	}
 
 	return cc.Handler.ServeChannel(cc, conn, newChannel)
 }
@@ -105,6 +114,10 @@ func (cc *ChannelChain) HandleRequests(ch ssh.Channel, reqs <-chan *ssh.Request,
 			req.Reply(false, nil)
 			continue
 		}
+		if ext, ok := requestPermits[req.Type]; ok && !cc.Permit(ext) {
+			req.Reply(false, nil)
+			continue
+		}
 
 		handler, ok := handlers[req.Type]
 		if !ok || handler == nil {
@@ -121,7 +134,19 @@ func (cc *ChannelChain) HandleRequests(ch ssh.Channel, reqs <-chan *ssh.Request,
 	}
 }
 
+// HandleCommand 依次执行 handlers. 设置了 ForceCommand 时以其代替 cmd,
+// 客户端请求的命令保存在 AcceptedEnvs 的 SSH_ORIGINAL_COMMAND 中.
 func (cc *ChannelChain) HandleCommand(cmd string, acceptedEnvs map[string]string, handlers []CommandHandler) {
+	if command, ok := cc.ForceCommand(); ok {
+		envs := make(map[string]string, len(acceptedEnvs)+1)
+		for k, v := range acceptedEnvs {
+			envs[k] = v
+		}
+		if len(cmd) > 0 {
+			envs["SSH_ORIGINAL_COMMAND"] = cmd
+		}
+		cmd, acceptedEnvs = command, envs
+	}
 	cc.RawCommand = cmd
 	cc.AcceptedEnvs = acceptedEnvs
 	cc.index = -1
@@ -163,25 +188,89 @@ func (cc *ChannelChain) ServerVersion() string {
 }
 
 func (cc *ChannelChain) ClientIP() string {
-	return cc.parseIP(cc.Conn.RemoteAddr())
+	return addrIP(cc.Conn.RemoteAddr())
 }
 
 func (cc *ChannelChain) ServerIP() string {
-	return cc.parseIP(cc.Conn.LocalAddr())
+	return addrIP(cc.Conn.LocalAddr())
 }
 
-func (cc *ChannelChain) parseIP(addr net.Addr) string {
-	if addr == nil {
+// addrIP 解析 net.Addr 中的 IP, 如 "127.0.0.1:22" 中的 "127.0.0.1".
+// Unix domain socket 的对端为 "unix:uid=1000" 的形式, 无凭证时为 "unix".
+func addrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case nil:
 		return ""
+	case *UnixPeerAddr:
+		return a.clientIP()
+	case *net.UnixAddr:
+		return "unix"
 	}
 
-	ip := net.ParseIP(addr.String())
+	host := addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
 	if len(ip) == 0 {
 		return ""
 	}
 	return ip.String()
 }
 
+// Listener 连接所属的 listener, 名称为 Listener.Name 或监听地址
+func (cc *ChannelChain) Listener() *Listener {
+	return cc.listener
+}
+
+// RemainingTime 距离连接或 session 达到最大时长的剩余时间, 不限制时 ok 为 false.
+func (cc *ChannelChain) RemainingTime() (remaining time.Duration, ok bool) {
+	if cc.Context == nil {
+		return 0, false
+	}
+	deadline, ok := cc.Deadline()
+	if !ok {
+		return 0, false
+	}
+	return time.Until(deadline), true
+}
+
+// requestPermits session request 需要的 permit-* 扩展, 未允许时 HandleRequests 拒绝该 request
+var requestPermits = map[string]string{
+	"pty-req":                    "permit-pty",
+	"x11-req":                    "permit-X11-forwarding",
+	"auth-agent-req@openssh.com": "permit-agent-forwarding",
+}
+
+// channelPermits channel 类型需要的 permit-* 扩展, 未允许时拒绝打开该 channel
+var channelPermits = map[string]string{
+	"direct-tcpip":                   "permit-port-forwarding",
+	"direct-streamlocal@openssh.com": "permit-port-forwarding",
+}
+
+// ForceCommand 认证时限制的命令, 即 authorized_keys 的 command 选项或证书的 force-command.
+func (cc *ChannelChain) ForceCommand() (command string, ok bool) {
+	if cc.Permissions == nil {
+		return "", false
+	}
+	command, ok = cc.Permissions.CriticalOptions["force-command"]
+	return command, ok
+}
+
+// Permit 判断是否允许 ext 对应的功能, 如 "permit-pty", "permit-port-forwarding".
+// 仅 Permissions.Extensions 中带有 PermExtKeyOptions 的连接 (如 KeyLookupAuth 认证的) 受限制,
+// 此时必须存在 ext; 其他连接总是允许.
+func (cc *ChannelChain) Permit(ext string) bool {
+	if cc.Permissions == nil {
+		return true
+	}
+	if _, restricted := cc.Permissions.Extensions[PermExtKeyOptions]; !restricted {
+		return true
+	}
+	_, ok := cc.Permissions.Extensions[ext]
+	return ok
+}
+
 func (cc *ChannelChain) PermExtensions(key string) string {
 	if cc.Permissions == nil || cc.Permissions.Extensions == nil {
 		return ""
@@ -204,6 +293,30 @@ func (cc *ChannelChain) PermCriticalOptions(key string) string {
 	return ""
 }
 
+// Principal 获取认证时附加的 Principal, 未附加时根据连接信息创建.
+// Fingerprint, KeyID 为空时以认证所用的公钥或证书填充.
+func (cc *ChannelChain) Principal() *Principal {
+	if cc.principal != nil {
+		return cc.principal
+	}
+
+	p, err := PrincipalFromPermissions(cc.Permissions)
+	if err != nil {
+		p = &Principal{}
+	}
+	if len(p.User) == 0 && cc.Conn != nil {
+		p.User = cc.Conn.User()
+	}
+	if len(p.Fingerprint) == 0 {
+		p.Fingerprint = cc.PermExtensions(PermExtFingerprint)
+	}
+	if len(p.KeyID) == 0 {
+		p.KeyID = cc.PermExtensions(PermExtCertKeyID)
+	}
+	cc.principal = p
+	return p
+}
+
 func (cc *ChannelChain) GetEnv(name string) string {
 	if cc.AcceptedEnvs == nil {
 		return ""
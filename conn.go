@@ -2,6 +2,7 @@ package sshd
 
 import (
 	"net"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,20 +16,37 @@ type Conn struct {
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 	idleTimeout  time.Duration
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
 }
 
 func (c *Conn) Read(b []byte) (int, error) {
 	if c.readTimeout > 0 {
 		c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
 	}
-	return c.Conn.Read(b)
+	n, err := c.Conn.Read(b)
+	c.bytesRead.Add(int64(n))
+	return n, err
 }
 
 func (c *Conn) Write(b []byte) (int, error) {
 	if c.writeTimeout > 0 {
 		c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
 	}
-	return c.Conn.Write(b)
+	n, err := c.Conn.Write(b)
+	c.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+// BytesRead 已读取的字节数, 包括 ssh 协议的开销
+func (c *Conn) BytesRead() int64 {
+	return c.bytesRead.Load()
+}
+
+// BytesWritten 已写入的字节数, 包括 ssh 协议的开销
+func (c *Conn) BytesWritten() int64 {
+	return c.bytesWritten.Load()
 }
 
 func (c *Conn) Close() error {
@@ -0,0 +1,63 @@
+package sshd
+
+import (
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ConnState 连接的状态, 用于 Server.ConnState
+type ConnState int
+
+const (
+	// StateNew 刚接受的连接, 尚未开始握手
+	StateNew ConnState = iota
+	// StateHandshaking 正在进行 ssh 握手与认证
+	StateHandshaking
+	// StateAuthenticated 认证通过, 之后转为 StateActive 或 StateClosed
+	StateAuthenticated
+	// StateActive 有打开的 channel
+	StateActive
+	// StateIdle 全部 channel 均已关闭
+	StateIdle
+	// StateClosed 连接已关闭, 为最终状态
+	StateClosed
+)
+
+var connStateName = map[ConnState]string{
+	StateNew:           "new",
+	StateHandshaking:   "handshaking",
+	StateAuthenticated: "authenticated",
+	StateActive:        "active",
+	StateIdle:          "idle",
+	StateClosed:        "closed",
+}
+
+func (s ConnState) String() string {
+	return connStateName[s]
+}
+
+// ConnStateHook 在连接的状态变化时调用. conn 为 listener 接受的原始连接 (ConnCallback 处理前),
+// 认证通过前 meta 为 nil. 同一连接的调用是串行的.
+type ConnStateHook func(conn net.Conn, state ConnState, meta ssh.ConnMetadata)
+
+func (srv *Server) setConnState(conn net.Conn, state ConnState, meta ssh.ConnMetadata) {
+	if srv.ConnState != nil {
+		srv.ConnState(conn, state, meta)
+	}
+}
+
+// setState 设置已认证连接的状态, StateClosed 之后不再变化.
+func (sc *serverConn) setState(state ConnState) {
+	sc.stateMut.Lock()
+	defer sc.stateMut.Unlock()
+	sc.setStateLocked(state)
+}
+
+func (sc *serverConn) setStateLocked(state ConnState) {
+	if sc.state == StateClosed {
+		return
+	}
+	sc.state = state
+	sc.srv.setConnState(sc.rawConn, state, sc.conn)
+}
@@ -0,0 +1,56 @@
+package sshd
+
+import (
+	"context"
+	"net"
+)
+
+// connValueContext 连接的 context, 取消继承自 Server 的 context,
+// 取值时依次查找 ConnContext 创建的 context, BaseContext 与 ServeContext 的 context.
+type connValueContext struct {
+	context.Context
+	values []context.Context
+}
+
+func (c connValueContext) Value(key interface{}) interface{} {
+	for _, ctx := range c.values {
+		if v := ctx.Value(key); v != nil {
+			return v
+		}
+	}
+	return c.Context.Value(key)
+}
+
+// baseContext 为 listener 创建 context, 取值依次查找 BaseContext 与 parent.
+func (srv *Server) baseContext(parent context.Context, ln net.Listener) context.Context {
+	if srv.BaseContext == nil {
+		return parent
+	}
+	base := srv.BaseContext(ln)
+	if base == nil {
+		return parent
+	}
+	return connValueContext{Context: base, values: []context.Context{base, parent}}
+}
+
+// newConnContext 为连接创建 context, 在 Server 关闭, ConnContext 创建的 context 被取消,
+// 或调用返回的 cancel (连接关闭) 时取消. base 仅提供值, 其取消将触发 Shutdown 而非直接取消连接.
+func (srv *Server) newConnContext(base context.Context, conn net.Conn) (context.Context, context.CancelFunc) {
+	values := context.Background()
+	if srv.ConnContext != nil {
+		if ctx := srv.ConnContext(conn); ctx != nil {
+			values = ctx
+		}
+	}
+	ctx, cancel := context.WithCancel(connValueContext{Context: srv.ctx, values: []context.Context{values, base}})
+	if done := values.Done(); done != nil {
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
@@ -0,0 +1,67 @@
+package sshd
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// PermExtMaxConnectionDuration, PermExtMaxSessionDuration 认证回调可在 Permissions.Extensions 中设置,
+	// 以 time.ParseDuration 的格式覆盖 Server 的 MaxConnectionDuration 与 MaxSessionDuration.
+	PermExtMaxConnectionDuration = "sshd-max-connection-duration"
+	PermExtMaxSessionDuration    = "sshd-max-session-duration"
+
+	// MaxDurationExitStatus 达到最大时长时 session 的 exit-status, 与 timeout(1) 一致.
+	MaxDurationExitStatus = 124
+
+	DefaultMaxDurationMessage = "sshd: maximum session duration exceeded"
+)
+
+// maxDuration 从 perms 中读取 ext 覆盖 global, 格式错误时使用 global.
+func (srv *Server) maxDuration(perms *ssh.Permissions, ext string, global time.Duration) time.Duration {
+	if perms == nil || perms.Extensions == nil {
+		return global
+	}
+	val, ok := perms.Extensions[ext]
+	if !ok {
+		return global
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		srv.logf("sshd: invalid permission extension %s=%q: %v", ext, val, err)
+		return global
+	}
+	return d
+}
+
+func (srv *Server) maxDurationMessage() string {
+	if len(srv.MaxDurationMessage) > 0 {
+		return srv.MaxDurationMessage
+	}
+	return DefaultMaxDurationMessage
+}
+
+// expire 连接达到最大时长, 结束全部 session 后关闭连接.
+func (sc *serverConn) expire() {
+	sc.srv.logf("sshd: %s of %s exceeded maximum connection duration, disconnecting", sc.conn.RemoteAddr(), sc.conn.User())
+	sessions := sc.sessions()
+	writeMessages(sessions, sc.srv.maxDurationMessage())
+	for _, ch := range sessions {
+		ch.exit(MaxDurationExitStatus)
+	}
+	_ = sc.closeWithMessage("")
+}
+
+// expire session 达到最大时长, 写入 msg 与 exit-status 后关闭.
+func (ch *trackedChannel) expire(msg string) {
+	writeMessages([]*trackedChannel{ch}, msg)
+	ch.exit(MaxDurationExitStatus)
+}
+
+// exit 发送 exit-status 后关闭 channel
+func (ch *trackedChannel) exit(status uint32) {
+	payload := struct{ Status uint32 }{Status: status}
+	_, _ = ch.Channel.SendRequest("exit-status", false, ssh.Marshal(payload))
+	_ = ch.Channel.Close()
+}
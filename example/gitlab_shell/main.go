@@ -33,22 +33,17 @@ func main() {
 			return proxyproto.NewConn(conn)
 		}),
 		sshd.WithGetSshServerConfig(func(ctx context.Context) *ssh.ServerConfig {
-			newSC := *defaultSshServerConf
-			f := &Fingerprint{ctx: ctx}
-			newSC.PublicKeyCallback = f.PublicKeyCallback
-			return &newSC
+			return defaultSshServerConf
 		}),
+		// the connection's context is passed in, no need to copy ssh.ServerConfig per connection
+		sshd.WithPublicKeyCallback(PublicKeyCallback),
 	}
 
 	err = sshd.ListenAndServe(":2222", mux, options...)
 	log.Printf("bye now. error:%v\n", err)
 }
 
-type Fingerprint struct {
-	ctx context.Context
-}
-
-func (f *Fingerprint) PublicKeyCallback(cm ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+func PublicKeyCallback(ctx context.Context, cm ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 	// do something
 	return &ssh.Permissions{
 		Extensions: map[string]string{
@@ -28,9 +28,11 @@ func main() {
 		return ctx
 	})
 
-	sshd.PublicKeyAuth(func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	// the connection's context is available in auth callbacks
+	publicKeyOption := sshd.WithPublicKeyCallback(func(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
 		fingerprint := ssh.FingerprintSHA256(key)
-		log.Printf("remote %s@%s, fingerprint %s", conn.User(), conn.RemoteAddr(), fingerprint)
+		log.Printf("remote %s@%s, fingerprint %s, uuid %v, vpceID %v",
+			conn.User(), conn.RemoteAddr(), fingerprint, ctx.Value("uuid"), ctx.Value("vpceID"))
 
 		return &ssh.Permissions{
 			Extensions: map[string]string{
@@ -43,7 +45,8 @@ func main() {
 		sshd.WithConnCallback(func(conn net.Conn) (newConn net.Conn) {
 			return proxyproto.NewConn(conn)
 		}),
-		connContextOption); err != nil {
+		connContextOption,
+		publicKeyOption); err != nil {
 		log.Println("serve error:", err)
 	}
 	log.Println("sshd exited")
@@ -7,6 +7,7 @@ require (
 	github.com/fango6/proxyproto v1.0.2
 	github.com/google/uuid v1.3.1
 	golang.org/x/crypto v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
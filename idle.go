@@ -0,0 +1,80 @@
+package sshd
+
+import (
+	"fmt"
+	"time"
+)
+
+const DefaultIdleTimeoutMessage = "sshd: idle timeout, disconnecting"
+
+// idleFor 连接的空闲时长. IdleOnlyWithoutChannels 时, 有打开的 channel 则不视为空闲.
+func (sc *serverConn) idleFor(now time.Time) time.Duration {
+	if sc.srv.IdleOnlyWithoutChannels && !sc.idle() {
+		return 0
+	}
+	return now.Sub(time.Unix(0, sc.lastActivity.Load()))
+}
+
+// watchIdle 在 channel 空闲 ChannelIdleTimeout 后断开连接, 并在断开前 IdleWarning 向交互式的 session 写入警告.
+// 连接的 context 被取消后返回.
+func (sc *serverConn) watchIdle() {
+	timeout := sc.srv.ChannelIdleTimeout
+	warnAt := timeout - sc.srv.IdleWarning
+	if sc.srv.IdleWarning <= 0 || warnAt <= 0 {
+		warnAt = timeout
+	}
+
+	warned := false
+	timer := time.NewTimer(warnAt)
+	defer timer.Stop()
+	for {
+		select {
+		case <-sc.ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		idle := sc.idleFor(time.Now())
+		switch {
+		case idle >= timeout:
+			sc.srv.logf("sshd: %s of %s idle for %s, disconnecting", sc.conn.RemoteAddr(), sc.conn.User(), idle.Round(time.Second))
+			sc.writeInteractive(DefaultIdleTimeoutMessage)
+			_ = sc.closeWithMessage("")
+			return
+
+		case idle >= warnAt:
+			if !warned {
+				warned = true
+				sc.writeInteractive(sc.srv.idleWarningMessage(timeout - idle))
+			}
+			timer.Reset(timeout - idle)
+
+		default:
+			warned = false
+			timer.Reset(warnAt - idle)
+		}
+	}
+}
+
+// writeInteractive 向分配了 pty 的 session 写入 msg
+func (sc *serverConn) writeInteractive(msg string) {
+	var interactive []*trackedChannel
+	for _, ch := range sc.sessions() {
+		if ch.pty.Load() {
+			interactive = append(interactive, ch)
+		}
+	}
+	writeMessages(interactive, msg)
+}
+
+func (srv *Server) idleWarningMessage(remaining time.Duration) string {
+	if len(srv.IdleWarningMessage) > 0 {
+		return srv.IdleWarningMessage
+	}
+	if remaining >= time.Second {
+		remaining = remaining.Round(time.Second)
+	} else {
+		remaining = remaining.Round(time.Millisecond * 100)
+	}
+	return fmt.Sprintf("sshd: connection idle, disconnecting in %s", remaining)
+}
@@ -0,0 +1,281 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultKeyLookupTimeout  = time.Second * 5
+	DefaultKeyLookupCacheTTL = time.Minute
+)
+
+var ErrKeyNotAuthorized = errors.New("sshd: public key not authorized")
+
+// KeyLookupFunc 根据用户与公钥查询 authorized_keys 格式的内容, 如调用外部命令或服务.
+type KeyLookupFunc func(ctx context.Context, user string, key ssh.PublicKey) ([]byte, error)
+
+// CommandKeyLookup 类似 OpenSSH 的 AuthorizedKeysCommand, 执行外部命令并读取其标准输出.
+// 参数中的以下标记会被替换:
+//
+//	%u 用户名
+//	%f 公钥的 SHA256 指纹
+//	%k base64 编码的公钥
+//	%t 公钥类型
+//	%% 字符 '%'
+func CommandKeyLookup(name string, args ...string) KeyLookupFunc {
+	return func(ctx context.Context, user string, key ssh.PublicKey) ([]byte, error) {
+		replacer := strings.NewReplacer(
+			"%%", "%",
+			"%u", user,
+			"%f", ssh.FingerprintSHA256(key),
+			"%k", base64.StdEncoding.EncodeToString(key.Marshal()),
+			"%t", key.Type(),
+		)
+		cmdArgs := make([]string, len(args))
+		for i, arg := range args {
+			cmdArgs[i] = replacer.Replace(arg)
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, name, cmdArgs...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("sshd: key lookup command %s: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+		}
+		return out, nil
+	}
+}
+
+// KeyLookupAuth 通过 KeyLookupFunc 查询 authorized_keys 内容进行公钥认证,
+// 并将匹配行的选项 (command, from, no-pty, restrict 等) 转换为 ssh.Permissions, 见 AuthorizedKeysPermissions.
+// 查询结果按用户与指纹缓存 CacheTTL, 查询出错时不缓存.
+type KeyLookupAuth struct {
+	Lookup KeyLookupFunc
+
+	// Timeout 单次查询的超时时间
+	Timeout time.Duration
+	// CacheTTL 查询结果的缓存时间, 小于等于 0 时不缓存
+	CacheTTL time.Duration
+
+	mut   sync.Mutex
+	cache map[string]keyLookupEntry
+}
+
+type keyLookupEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+var (
+	_ Authenticator     = &KeyLookupAuth{}
+	_ AuthMethodChecker = &KeyLookupAuth{}
+)
+
+// NewKeyLookupAuth 创建 KeyLookupAuth, 使用默认的超时与缓存时间.
+func NewKeyLookupAuth(lookup KeyLookupFunc) *KeyLookupAuth {
+	return &KeyLookupAuth{
+		Lookup:   lookup,
+		Timeout:  DefaultKeyLookupTimeout,
+		CacheTTL: DefaultKeyLookupCacheTTL,
+		cache:    make(map[string]keyLookupEntry),
+	}
+}
+
+// PublicKey implements Authenticator.PublicKey
+func (ka *KeyLookupAuth) PublicKey(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	data, err := ka.lookup(ctx, conn.User(), key)
+	if err != nil {
+		return nil, err
+	}
+	return AuthorizedKeysPermissions(data, key, addrIP(conn.RemoteAddr()))
+}
+
+// Password implements Authenticator.Password
+func (ka *KeyLookupAuth) Password(ctx context.Context, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	return nil, ErrAuthNotSupported
+}
+
+// KeyboardInteractive implements Authenticator.KeyboardInteractive
+func (ka *KeyLookupAuth) KeyboardInteractive(ctx context.Context, conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return nil, ErrAuthNotSupported
+}
+
+// SupportsAuthMethod implements AuthMethodChecker
+func (ka *KeyLookupAuth) SupportsAuthMethod(method string) bool {
+	return method == AuthMethodPublicKey
+}
+
+// Purge 清空缓存
+func (ka *KeyLookupAuth) Purge() {
+	ka.mut.Lock()
+	ka.cache = make(map[string]keyLookupEntry)
+	ka.mut.Unlock()
+}
+
+func (ka *KeyLookupAuth) lookup(ctx context.Context, user string, key ssh.PublicKey) ([]byte, error) {
+	if ka.Lookup == nil {
+		return nil, ErrAuthNotSupported
+	}
+
+	cacheKey := user + "\x00" + ssh.FingerprintSHA256(key)
+	now := time.Now()
+	if ka.CacheTTL > 0 {
+		ka.mut.Lock()
+		entry, ok := ka.cache[cacheKey]
+		ka.mut.Unlock()
+		if ok && now.Before(entry.expires) {
+			return entry.data, nil
+		}
+	}
+
+	if ka.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ka.Timeout)
+		defer cancel()
+	}
+	data, err := ka.Lookup(ctx, user, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if ka.CacheTTL > 0 {
+		ka.mut.Lock()
+		if ka.cache == nil {
+			ka.cache = make(map[string]keyLookupEntry)
+		}
+		// 顺带清理过期的缓存
+		for k, e := range ka.cache {
+			if now.After(e.expires) {
+				delete(ka.cache, k)
+			}
+		}
+		ka.cache[cacheKey] = keyLookupEntry{data: data, expires: now.Add(ka.CacheTTL)}
+		ka.mut.Unlock()
+	}
+	return data, nil
+}
+
+// AuthorizedKeysPermissions 在 authorized_keys 格式的内容中查找 key,
+// 并将其选项转换为 ssh.Permissions. clientIP 用于校验 from 选项.
+//
+// 支持的选项: command, from, expiry-time, restrict, no-pty, no-port-forwarding,
+// no-agent-forwarding, no-X11-forwarding, no-user-rc 及 restrict 之后的 pty 等.
+// from 与 expiry-time 在认证时检查; command 转换为 CriticalOptions 的 force-command,
+// 其余转换为 Extensions 的 permit-*, 并附加 PermExtKeyOptions. 二者由 ChannelChain 执行:
+// HandleCommand 以 force-command 代替客户端的命令, HandleRequests 拒绝未允许的 pty-req 等,
+// 未允许端口转发时拒绝 direct-tcpip channel. 不使用 HandleCommand, HandleRequests 的 handler
+// 须自行检查 ChannelChain.ForceCommand 与 Permit. permit-user-rc 不被执行.
+// 带 cert-authority 的行将被忽略. 与 OpenSSH 一致, from 不匹配或已过期的行将被跳过并继续查找,
+// 均不适用时返回最后一个这样的错误.
+func AuthorizedKeysPermissions(data []byte, key ssh.PublicKey, clientIP string) (*ssh.Permissions, error) {
+	keyBytes := key.Marshal()
+	lastErr := ErrKeyNotAuthorized
+	for len(data) > 0 {
+		out, _, options, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			// 剩余内容中没有合法的公钥
+			break
+		}
+		data = rest
+
+		if !bytes.Equal(out.Marshal(), keyBytes) {
+			continue
+		}
+		perms, err := authorizedKeyOptions(options, clientIP)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if perms != nil {
+			return perms, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// PermExtKeyOptions 存在于 ssh.Permissions.Extensions 时, 表示 Permissions 由 authorized_keys 的选项转换而来,
+// ChannelChain.Permit 仅对这样的连接检查 permit-* 扩展.
+const PermExtKeyOptions = "sshd-key-options"
+
+// 默认允许的扩展, 与 OpenSSH 证书的默认扩展一致
+var defaultPermitExtensions = []string{
+	"permit-X11-forwarding",
+	"permit-agent-forwarding",
+	"permit-port-forwarding",
+	"permit-pty",
+	"permit-user-rc",
+}
+
+// authorizedKeyOptions 转换 authorized_keys 的选项, 返回 nil 表示该行不适用 (如 cert-authority).
+func authorizedKeyOptions(options []string, clientIP string) (*ssh.Permissions, error) {
+	perms := &ssh.Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      map[string]string{PermExtKeyOptions: ""},
+	}
+	for _, ext := range defaultPermitExtensions {
+		perms.Extensions[ext] = ""
+	}
+
+	for _, option := range options {
+		name, value, hasValue := strings.Cut(option, "=")
+		if hasValue {
+			value = strings.Trim(value, `"`)
+		}
+
+		switch strings.ToLower(name) {
+		case "cert-authority":
+			return nil, nil
+		case "command":
+			perms.CriticalOptions["force-command"] = value
+		case "from":
+			if !matchList(clientIP, value, matchAddr) {
+				return nil, fmt.Errorf("sshd: client %s not allowed by from=%q", clientIP, value)
+			}
+		case "expiry-time":
+			expiry, err := parseExpiryTime(value)
+			if err != nil {
+				return nil, err
+			}
+			if time.Now().After(expiry) {
+				return nil, fmt.Errorf("sshd: public key expired at %s", expiry.Format(time.RFC3339))
+			}
+		case "restrict":
+			for _, ext := range defaultPermitExtensions {
+				delete(perms.Extensions, ext)
+			}
+		case "no-pty", "no-port-forwarding", "no-agent-forwarding", "no-x11-forwarding", "no-user-rc":
+			delete(perms.Extensions, permitExtension(name[len("no-"):]))
+		case "pty", "port-forwarding", "agent-forwarding", "x11-forwarding", "user-rc":
+			perms.Extensions[permitExtension(name)] = ""
+		}
+	}
+	return perms, nil
+}
+
+func permitExtension(feature string) string {
+	if strings.EqualFold(feature, "x11-forwarding") {
+		return "permit-X11-forwarding"
+	}
+	return "permit-" + strings.ToLower(feature)
+}
+
+// parseExpiryTime 解析 YYYYMMDD[HHMM[SS]] 格式的本地时间
+func parseExpiryTime(value string) (time.Time, error) {
+	for _, layout := range []string{"20060102150405", "200601021504", "20060102"} {
+		if len(value) == len(layout) {
+			return time.ParseInLocation(layout, value, time.Local)
+		}
+	}
+	return time.Time{}, fmt.Errorf("sshd: invalid expiry-time %q", value)
+}
@@ -0,0 +1,94 @@
+package sshd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthorizedKeysPermissionsSkipsUnmatchedLines(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	data := []byte(strings.Join([]string{
+		`from="10.0.0.0/8",command="internal" ` + line,
+		`expiry-time="20000101" ` + line,
+		`from="192.168.1.*",no-pty ` + line,
+	}, "\n"))
+
+	perms, err := AuthorizedKeysPermissions(data, signer.PublicKey(), "192.168.1.7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := perms.Extensions["permit-pty"]; ok || len(perms.CriticalOptions["force-command"]) > 0 {
+		t.Errorf("matched the wrong line: %+v", perms)
+	}
+
+	perms, err = AuthorizedKeysPermissions(data, signer.PublicKey(), "10.1.2.3")
+	if err != nil || perms.CriticalOptions["force-command"] != "internal" {
+		t.Errorf("want the first line, got %+v, %v", perms, err)
+	}
+
+	if _, err := AuthorizedKeysPermissions(data, signer.PublicKey(), "172.16.0.1"); err == nil {
+		t.Error("want error for client matching no line")
+	}
+}
+
+func TestChannelChainEnforcesKeyOptions(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	perms, err := AuthorizedKeysPermissions([]byte(`restrict,pty,command="internal" `+line), signer.PublicKey(), "10.1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := NewChannelChain(nil, nil)
+	cc.Permissions = perms
+	for ext, want := range map[string]bool{
+		"permit-pty":              true,
+		"permit-port-forwarding":  false,
+		"permit-agent-forwarding": false,
+	} {
+		if got := cc.Permit(ext); got != want {
+			t.Errorf("Permit(%s) = %v, want %v", ext, got, want)
+		}
+	}
+	// 非 authorized_keys 的认证不受限制
+	if !NewChannelChain(nil, nil).Permit("permit-port-forwarding") {
+		t.Error("want unrestricted without key options")
+	}
+
+	served := make(map[string]bool)
+	record := RequestHandlerFunc(func(cc *ChannelChain, req *ssh.Request) (bool, []byte) {
+		served[req.Type] = true
+		return true, nil
+	})
+	reqs := make(chan *ssh.Request, 3)
+	for _, typ := range []string{"pty-req", "auth-agent-req@openssh.com", "env"} {
+		reqs <- &ssh.Request{Type: typ}
+	}
+	close(reqs)
+	cc.HandleRequests(nil, reqs, map[string]RequestHandler{"default": record})
+	if !served["pty-req"] || !served["env"] || served["auth-agent-req@openssh.com"] {
+		t.Errorf("unexpected requests served: %v", served)
+	}
+
+	var command, original string
+	cc.HandleCommand("rm -rf /", nil, []CommandHandler{CommandHandlerFunc(func(cc *ChannelChain) error {
+		command, original = cc.RawCommand, cc.AcceptedEnvs["SSH_ORIGINAL_COMMAND"]
+		return nil
+	})})
+	if command != "internal" || original != "rm -rf /" {
+		t.Errorf("want forced command internal with original rm -rf /, got %q, %q", command, original)
+	}
+}
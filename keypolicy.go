@@ -0,0 +1,132 @@
+package sshd
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var ErrKeyPolicy = errors.New("sshd: public key rejected by policy")
+
+// KeyPolicy 客户端公钥的算法与强度策略, 通过 Wrap 包装任意 PublicKeyCallback,
+// 不合规的公钥在用户回调执行前即被拒绝, 并输出拒绝原因.
+type KeyPolicy struct {
+	// MinRSABits RSA 公钥的最小位数, 为 0 时不限制
+	MinRSABits int
+	// AllowDSA 是否允许 DSA (ssh-dss) 公钥
+	AllowDSA bool
+	// DisallowedCurves 禁用的 ECDSA 曲线, 如 "nistp256", "nistp384", "nistp521"
+	DisallowedCurves []string
+
+	// RequireCertificate 要求使用证书认证
+	RequireCertificate bool
+	// RequireSecurityKey 要求使用 FIDO/U2F security key (sk-ecdsa, sk-ed25519), 仅检查公钥类型
+	RequireSecurityKey bool
+	// RejectNoTouchRequired 拒绝带有 no-touch-required 扩展的证书.
+	// 注意: 不会校验签名中的 user-presence 标志, golang.org/x/crypto/ssh 校验 security key 签名时
+	// 忽略该标志, 且签名在回调之后才校验, 回调中无法获取; 因此无法保证认证时用户触摸过设备.
+	RejectNoTouchRequired bool
+
+	// ErrLogger 输出拒绝原因, 默认为 log.Default
+	ErrLogger *log.Logger
+}
+
+// Check 检查公钥是否合规, 不合规时返回的错误包装了 ErrKeyPolicy.
+func (kp *KeyPolicy) Check(key ssh.PublicKey) error {
+	underlying := key
+	cert, isCert := key.(*ssh.Certificate)
+	if isCert {
+		underlying = cert.Key
+	}
+
+	if kp.RequireCertificate && !isCert {
+		return fmt.Errorf("%w: certificate required", ErrKeyPolicy)
+	}
+
+	keyType := underlying.Type()
+	switch keyType {
+	case ssh.KeyAlgoDSA:
+		if !kp.AllowDSA {
+			return fmt.Errorf("%w: DSA keys are not allowed", ErrKeyPolicy)
+		}
+
+	case ssh.KeyAlgoRSA:
+		if kp.MinRSABits > 0 {
+			bits := rsaKeyBits(underlying)
+			if bits < kp.MinRSABits {
+				return fmt.Errorf("%w: RSA key has %d bits, at least %d required", ErrKeyPolicy, bits, kp.MinRSABits)
+			}
+		}
+	}
+
+	for _, curve := range kp.DisallowedCurves {
+		if strings.Contains(keyType, "ecdsa-sha2-"+curve) {
+			return fmt.Errorf("%w: curve %s is not allowed", ErrKeyPolicy, curve)
+		}
+	}
+
+	isSecurityKey := keyType == ssh.KeyAlgoSKECDSA256 || keyType == ssh.KeyAlgoSKED25519
+	if kp.RequireSecurityKey && !isSecurityKey {
+		return fmt.Errorf("%w: security key required, got %s", ErrKeyPolicy, keyType)
+	}
+	if kp.RejectNoTouchRequired && isCert {
+		if _, ok := cert.Extensions["no-touch-required"]; ok {
+			return fmt.Errorf("%w: certificate permits no-touch-required", ErrKeyPolicy)
+		}
+	}
+	return nil
+}
+
+// Wrap 包装 PublicKeyCallback, 公钥合规后才调用 next.
+func (kp *KeyPolicy) Wrap(next PublicKeyCallback) PublicKeyCallback {
+	return func(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if err := kp.check(conn, key); err != nil {
+			return nil, err
+		}
+		return next(ctx, conn, key)
+	}
+}
+
+// WrapCallback 包装 ssh.ServerConfig.PublicKeyCallback, 公钥合规后才调用 next.
+func (kp *KeyPolicy) WrapCallback(next func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error)) func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+	return func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if err := kp.check(conn, key); err != nil {
+			return nil, err
+		}
+		return next(conn, key)
+	}
+}
+
+func (kp *KeyPolicy) check(conn ssh.ConnMetadata, key ssh.PublicKey) error {
+	err := kp.Check(key)
+	if err != nil {
+		kp.logf("sshd: reject %s key %s of %s from %s: %v",
+			key.Type(), ssh.FingerprintSHA256(key), conn.User(), conn.RemoteAddr(), err)
+	}
+	return err
+}
+
+func (kp *KeyPolicy) logf(format string, args ...interface{}) {
+	if kp.ErrLogger != nil {
+		kp.ErrLogger.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func rsaKeyBits(key ssh.PublicKey) int {
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey)
+	if !ok {
+		return 0
+	}
+	return rsaKey.N.BitLen()
+}
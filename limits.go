@@ -0,0 +1,153 @@
+package sshd
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MaxStartups 与 OpenSSH 的 MaxStartups start:rate:full 一致, 限制未完成认证的连接数.
+// 未认证的连接数达到 Start 后, 以 Rate% 的概率丢弃新连接, 概率随连接数线性增加, 达到 Full 时丢弃全部新连接.
+type MaxStartups struct {
+	Start int
+	Rate  int
+	Full  int
+}
+
+// ParseMaxStartups 解析 "10:30:100" 或 "10" 格式的 MaxStartups
+func ParseMaxStartups(s string) (*MaxStartups, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 1 && len(parts) != 3 {
+		return nil, fmt.Errorf("sshd: invalid MaxStartups %q", s)
+	}
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("sshd: invalid MaxStartups %q", s)
+		}
+		nums[i] = n
+	}
+
+	ms := &MaxStartups{Start: nums[0], Rate: 100, Full: nums[0]}
+	if len(nums) == 3 {
+		ms.Rate, ms.Full = nums[1], nums[2]
+	}
+	if err := ms.validate(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+func (ms *MaxStartups) validate() error {
+	if ms.Start <= 0 || ms.Rate <= 0 || ms.Rate > 100 || ms.Full < ms.Start {
+		return fmt.Errorf("sshd: invalid MaxStartups %d:%d:%d", ms.Start, ms.Rate, ms.Full)
+	}
+	return nil
+}
+
+// drop 已有 n 个未认证的连接时, 是否丢弃新连接
+func (ms *MaxStartups) drop(n int) bool {
+	if n < ms.Start {
+		return false
+	}
+	if n >= ms.Full {
+		return true
+	}
+	p := ms.Rate + (100-ms.Rate)*(n-ms.Start)/(ms.Full-ms.Start)
+	return rand.Intn(100) < p
+}
+
+// connLimiter 统计连接数, 用于 MaxConnections 等限制
+type connLimiter struct {
+	mut             sync.Mutex
+	total           int
+	unauthenticated int
+	perIP           map[string]int
+	perUser         map[string]int
+}
+
+// acquireConn 在 Accept 后检查 MaxConnections
+func (srv *Server) acquireConn() bool {
+	l := &srv.limiter
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if srv.MaxConnections > 0 && l.total >= srv.MaxConnections {
+		return false
+	}
+	l.total++
+	return true
+}
+
+func (srv *Server) releaseConn() {
+	l := &srv.limiter
+	l.mut.Lock()
+	l.total--
+	l.mut.Unlock()
+}
+
+// acquireStartup 在握手前检查 MaxConnectionsPerIP 与 MaxStartups, 返回的 release 函数须在连接关闭后调用,
+// authenticated 须在认证完成 (无论成功与否) 后调用.
+func (srv *Server) acquireStartup(ip string) (authenticated, release func(), err error) {
+	l := &srv.limiter
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if srv.MaxConnectionsPerIP > 0 && l.perIP[ip] >= srv.MaxConnectionsPerIP {
+		return nil, nil, fmt.Errorf("too many connections from %s, max %d", ip, srv.MaxConnectionsPerIP)
+	}
+	if ms := srv.MaxStartups; ms != nil && ms.drop(l.unauthenticated) {
+		return nil, nil, fmt.Errorf("drop connection from %s, %d unauthenticated connections, MaxStartups %d:%d:%d",
+			ip, l.unauthenticated, ms.Start, ms.Rate, ms.Full)
+	}
+
+	if l.perIP == nil {
+		l.perIP = make(map[string]int)
+	}
+	l.perIP[ip]++
+	l.unauthenticated++
+
+	var authOnce sync.Once
+	authenticated = func() {
+		authOnce.Do(func() {
+			l.mut.Lock()
+			l.unauthenticated--
+			l.mut.Unlock()
+		})
+	}
+	release = func() {
+		authenticated()
+		l.mut.Lock()
+		if l.perIP[ip]--; l.perIP[ip] <= 0 {
+			delete(l.perIP, ip)
+		}
+		l.mut.Unlock()
+	}
+	return authenticated, release, nil
+}
+
+// acquireUser 在认证通过后检查 MaxSessionsPerUser
+func (srv *Server) acquireUser(user string) (release func(), err error) {
+	l := &srv.limiter
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if srv.MaxSessionsPerUser > 0 && l.perUser[user] >= srv.MaxSessionsPerUser {
+		return nil, fmt.Errorf("too many connections of user %s, max %d", user, srv.MaxSessionsPerUser)
+	}
+
+	if l.perUser == nil {
+		l.perUser = make(map[string]int)
+	}
+	l.perUser[user]++
+	return func() {
+		l.mut.Lock()
+		if l.perUser[user]--; l.perUser[user] <= 0 {
+			delete(l.perUser, user)
+		}
+		l.mut.Unlock()
+	}, nil
+}
@@ -0,0 +1,209 @@
+package sshd
+
+import (
+	"testing"
+)
+
+func TestParseMaxStartups(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    MaxStartups
+		wantErr bool
+	}{
+		{s: "10:30:100", want: MaxStartups{Start: 10, Rate: 30, Full: 100}},
+		{s: "10", want: MaxStartups{Start: 10, Rate: 100, Full: 10}},
+		{s: "1:100:1", want: MaxStartups{Start: 1, Rate: 100, Full: 1}},
+		{s: "", wantErr: true},
+		{s: "0", wantErr: true},
+		{s: "-1", wantErr: true},
+		{s: "10:30", wantErr: true},
+		{s: "10:30:100:1", wantErr: true},
+		{s: "a:30:100", wantErr: true},
+		{s: "10:0:100", wantErr: true},
+		{s: "10:101:100", wantErr: true},
+		{s: "10:30:5", wantErr: true},
+	}
+	for _, c := range cases {
+		ms, err := ParseMaxStartups(c.s)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("ParseMaxStartups(%q): want error, got %+v", c.s, ms)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseMaxStartups(%q): %v", c.s, err)
+			continue
+		}
+		if *ms != c.want {
+			t.Errorf("ParseMaxStartups(%q) = %+v, want %+v", c.s, *ms, c.want)
+		}
+	}
+}
+
+func TestMaxStartupsDrop(t *testing.T) {
+	cases := []struct {
+		ms   MaxStartups
+		n    int
+		want bool
+		// random 丢弃的概率在 0 与 100 之间, 只检查结果不总是相同
+		random bool
+	}{
+		{ms: MaxStartups{Start: 10, Rate: 30, Full: 100}, n: 0, want: false},
+		{ms: MaxStartups{Start: 10, Rate: 30, Full: 100}, n: 9, want: false},
+		{ms: MaxStartups{Start: 10, Rate: 30, Full: 100}, n: 10, random: true},
+		{ms: MaxStartups{Start: 10, Rate: 30, Full: 100}, n: 55, random: true},
+		{ms: MaxStartups{Start: 10, Rate: 30, Full: 100}, n: 100, want: true},
+		{ms: MaxStartups{Start: 10, Rate: 30, Full: 100}, n: 1000, want: true},
+		{ms: MaxStartups{Start: 10, Rate: 100, Full: 10}, n: 9, want: false},
+		{ms: MaxStartups{Start: 10, Rate: 100, Full: 10}, n: 10, want: true},
+		// Rate 为 100 时达到 Start 即全部丢弃
+		{ms: MaxStartups{Start: 10, Rate: 100, Full: 20}, n: 10, want: true},
+	}
+	for _, c := range cases {
+		dropped := 0
+		const tries = 1000
+		for i := 0; i < tries; i++ {
+			if c.ms.drop(c.n) {
+				dropped++
+			}
+		}
+		if c.random {
+			if dropped == 0 || dropped == tries {
+				t.Errorf("%+v drop(%d): dropped %d of %d, want random", c.ms, c.n, dropped, tries)
+			}
+			continue
+		}
+		want := 0
+		if c.want {
+			want = tries
+		}
+		if dropped != want {
+			t.Errorf("%+v drop(%d): dropped %d of %d, want %d", c.ms, c.n, dropped, tries, want)
+		}
+	}
+}
+
+func TestWithMaxStartupsValidates(t *testing.T) {
+	cases := []struct {
+		start, rate, full int
+		wantPanic         bool
+	}{
+		{10, 30, 100, false},
+		{0, 30, 100, true},
+		{10, 0, 100, true},
+		{10, 30, 5, true},
+	}
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if r := recover(); (r != nil) != c.wantPanic {
+					t.Errorf("WithMaxStartups(%d, %d, %d): panic %v, want panic %v", c.start, c.rate, c.full, r, c.wantPanic)
+				}
+			}()
+			srv := NewServer(NewServeMux(), WithMaxStartups(c.start, c.rate, c.full))
+			if srv.MaxStartups.drop(0) {
+				t.Errorf("WithMaxStartups(%d, %d, %d) drops the first connection", c.start, c.rate, c.full)
+			}
+		}()
+	}
+}
+
+func TestAcquireStartup(t *testing.T) {
+	srv := NewServer(NewServeMux(), WithMaxConnectionsPerIP(2), WithMaxStartups(3, 100, 3))
+	l := &srv.limiter
+
+	authA, releaseA, err := srv.acquireStartup("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, releaseB, err := srv.acquireStartup("10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := srv.acquireStartup("10.0.0.1"); err == nil {
+		t.Error("want error over MaxConnectionsPerIP")
+	}
+
+	// 未认证的连接数达到 MaxStartups 的 Full
+	_, releaseC, err := srv.acquireStartup("10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := srv.acquireStartup("10.0.0.3"); err == nil {
+		t.Error("want error over MaxStartups")
+	}
+
+	// 认证完成后不再计入未认证的连接, 重复调用只计一次
+	authA()
+	authA()
+	if l.unauthenticated != 2 {
+		t.Errorf("want 2 unauthenticated, got %d", l.unauthenticated)
+	}
+	_, releaseD, err := srv.acquireStartup("10.0.0.3")
+	if err != nil {
+		t.Fatalf("want accepted after authentication, got %v", err)
+	}
+
+	// release 同时结束未认证的计数
+	releaseA()
+	releaseB()
+	releaseC()
+	releaseD()
+	if l.unauthenticated != 0 || len(l.perIP) != 0 {
+		t.Errorf("want counters released, got %d unauthenticated, perIP %v", l.unauthenticated, l.perIP)
+	}
+}
+
+func TestAcquireUser(t *testing.T) {
+	cases := []struct {
+		max     int
+		n       int
+		wantErr bool
+	}{
+		{max: 0, n: 10},
+		{max: 2, n: 2},
+		{max: 2, n: 3, wantErr: true},
+	}
+	for _, c := range cases {
+		srv := NewServer(NewServeMux(), WithMaxSessionsPerUser(c.max))
+		var releases []func()
+		var err error
+		for i := 0; i < c.n && err == nil; i++ {
+			var release func()
+			if release, err = srv.acquireUser("alice"); err == nil {
+				releases = append(releases, release)
+			}
+		}
+		if (err != nil) != c.wantErr {
+			t.Errorf("max %d, %d connections: got error %v, want error %v", c.max, c.n, err, c.wantErr)
+		}
+		// 其他用户不受影响
+		if release, err := srv.acquireUser("bob"); err != nil {
+			t.Errorf("max %d: other user rejected: %v", c.max, err)
+		} else {
+			release()
+		}
+
+		for _, release := range releases {
+			release()
+		}
+		if len(srv.limiter.perUser) != 0 {
+			t.Errorf("max %d: want counters released, got %v", c.max, srv.limiter.perUser)
+		}
+	}
+}
+
+func TestAcquireConn(t *testing.T) {
+	srv := NewServer(NewServeMux(), WithMaxConnections(1))
+	if !srv.acquireConn() {
+		t.Fatal("want first connection accepted")
+	}
+	if srv.acquireConn() {
+		t.Error("want second connection rejected")
+	}
+	srv.releaseConn()
+	if !srv.acquireConn() {
+		t.Error("want connection accepted after release")
+	}
+}
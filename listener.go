@@ -0,0 +1,110 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listener 为单个 listener 设置独立的连接处理与超时, 可传入 Server.Serve.
+// 未设置 (为 nil 或 0) 的字段使用 Server 的配置. 同一 Server 的多个 Listener 共享连接注册表与关闭.
+type Listener struct {
+	net.Listener
+
+	// Name listener 的名称, 如 "public", "internal", 为空时为监听地址
+	Name string
+
+	ConnCallback       ConnCallback
+	GetSshServerConfig GetSshServerConfig
+
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	LoginGraceTime time.Duration
+}
+
+// String listener 的名称
+func (l *Listener) String() string {
+	if len(l.Name) > 0 {
+		return l.Name
+	}
+	return l.Addr().String()
+}
+
+// asListener 将 ln 转为 *Listener, 非 *Listener 时使用 Server 的配置
+func asListener(ln net.Listener) *Listener {
+	if l, ok := ln.(*Listener); ok {
+		return l
+	}
+	return &Listener{Listener: ln}
+}
+
+func (l *Listener) connCallback(srv *Server) ConnCallback {
+	if l.ConnCallback != nil {
+		return l.ConnCallback
+	}
+	return srv.ConnCallback
+}
+
+func (l *Listener) getSshServerConfig(srv *Server) GetSshServerConfig {
+	if l.GetSshServerConfig != nil {
+		return l.GetSshServerConfig
+	}
+	return srv.GetSshServerConfig
+}
+
+func (l *Listener) readTimeout(srv *Server) time.Duration {
+	return durationOr(l.ReadTimeout, srv.ReadTimeout)
+}
+
+func (l *Listener) writeTimeout(srv *Server) time.Duration {
+	return durationOr(l.WriteTimeout, srv.WriteTimeout)
+}
+
+func (l *Listener) idleTimeout(srv *Server) time.Duration {
+	return durationOr(l.IdleTimeout, srv.IdleTimeout)
+}
+
+func (l *Listener) loginGraceTime(srv *Server) time.Duration {
+	return durationOr(l.LoginGraceTime, srv.LoginGraceTime)
+}
+
+func durationOr(d, fallback time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// ServeListeners 同时在多个 listener 上提供服务, 在全部返回后返回, ctx 的用法见 ServeContext.
+// 返回第一个非 ErrServerClosed 的错误, 均为 ErrServerClosed 时返回 ErrServerClosed.
+func (srv *Server) ServeListeners(ctx context.Context, listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("sshd: no listeners")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	wait := srv.shutdownWhenDone(ctx)
+	defer wait()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(listeners))
+	for i, ln := range listeners {
+		wg.Add(1)
+		go func(i int, ln net.Listener) {
+			defer wg.Done()
+			errs[i] = srv.serve(ctx, ln)
+		}(i, ln)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, ErrServerClosed) {
+			return err
+		}
+	}
+	return ErrServerClosed
+}
@@ -0,0 +1,322 @@
+package sshd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrAccountLocked = errors.New("sshd: account locked")
+
+// lockoutPruneInterval 清理过期失败记录的最小间隔
+const lockoutPruneInterval = time.Minute
+
+// LockoutState 账户的失败记录
+type LockoutState struct {
+	Failures     int       `json:"failures"`
+	FirstFailure time.Time `json:"first_failure"`
+	LockedUntil  time.Time `json:"locked_until,omitempty"`
+	// ExpiresAt 失败记录不再影响认证的时间, 即冷却结束或超出时间窗口, 为零值时不过期.
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired 失败记录是否已过期, 过期的记录可被删除
+func (s LockoutState) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// LockoutStore 保存账户的失败记录, 可自行实现以持久化到数据库等.
+type LockoutStore interface {
+	Get(user string) (state LockoutState, ok bool, err error)
+	Put(user string, state LockoutState) error
+	Delete(user string) error
+}
+
+// AccountLockout 在时间窗口 Window 内, 账户认证失败达到 Threshold 次后锁定 Cooldown,
+// 与客户端 IP 无关, 可抵御分布式的密码猜测. 冷却结束后自动解锁, 也可通过 Unlock 手动解锁.
+type AccountLockout struct {
+	Threshold int
+	Window    time.Duration
+	Cooldown  time.Duration
+
+	// Methods 计入失败次数的认证方式, 为空时为 password 与 keyboard-interactive.
+	// 客户端会逐个尝试代理中的公钥, 通常不应计入 publickey.
+	Methods []string
+
+	// Store 为 nil 时使用 MemoryLockoutStore
+	Store LockoutStore
+
+	mut sync.Mutex
+}
+
+// NewAccountLockout 创建使用内存存储的 AccountLockout
+func NewAccountLockout(threshold int, window, cooldown time.Duration) *AccountLockout {
+	return &AccountLockout{
+		Threshold: threshold,
+		Window:    window,
+		Cooldown:  cooldown,
+		Store:     NewMemoryLockoutStore(),
+	}
+}
+
+func (al *AccountLockout) store() LockoutStore {
+	if al.Store == nil {
+		al.Store = NewMemoryLockoutStore()
+	}
+	return al.Store
+}
+
+// Check 账户被锁定时返回的错误包装了 ErrAccountLocked
+func (al *AccountLockout) Check(user string) error {
+	if locked, until := al.Locked(user); locked {
+		return fmt.Errorf("%w: %s until %s", ErrAccountLocked, user, until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Locked 判断账户是否被锁定及解锁时间
+func (al *AccountLockout) Locked(user string) (bool, time.Time) {
+	al.mut.Lock()
+	defer al.mut.Unlock()
+
+	state, ok, err := al.store().Get(user)
+	if err != nil || !ok {
+		return false, time.Time{}
+	}
+	if time.Now().Before(state.LockedUntil) {
+		return true, state.LockedUntil
+	}
+	return false, time.Time{}
+}
+
+// CountsMethod 判断 method 的失败是否计入
+func (al *AccountLockout) CountsMethod(method string) bool {
+	if len(al.Methods) == 0 {
+		return method == AuthMethodPassword || method == AuthMethodKeyboardInteractive
+	}
+	for _, m := range al.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Fail 记录一次失败, 返回本次是否导致账户被锁定.
+func (al *AccountLockout) Fail(user string) (locked bool, err error) {
+	al.mut.Lock()
+	defer al.mut.Unlock()
+
+	store := al.store()
+	state, _, err := store.Get(user)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if now.Before(state.LockedUntil) {
+		return false, nil
+	}
+	// 冷却结束或超出时间窗口后重新计数
+	if state.Failures == 0 || !state.LockedUntil.IsZero() || (al.Window > 0 && now.Sub(state.FirstFailure) > al.Window) {
+		state = LockoutState{FirstFailure: now}
+	}
+	state.Failures++
+	if al.Threshold > 0 && state.Failures >= al.Threshold {
+		state.LockedUntil = now.Add(al.Cooldown)
+		state.ExpiresAt = state.LockedUntil
+		locked = true
+	} else if al.Window > 0 {
+		state.ExpiresAt = state.FirstFailure.Add(al.Window)
+	}
+	return locked, store.Put(user, state)
+}
+
+// Succeed 认证成功后清空失败记录
+func (al *AccountLockout) Succeed(user string) error {
+	return al.Unlock(user)
+}
+
+// Unlock 手动解锁账户并清空失败记录
+func (al *AccountLockout) Unlock(user string) error {
+	al.mut.Lock()
+	defer al.mut.Unlock()
+	return al.store().Delete(user)
+}
+
+// MemoryLockoutStore 内存中的 LockoutStore, 重启后失败记录丢失.
+// Put 时定期删除过期的失败记录, 避免大量不存在的用户名使内存无限增长.
+type MemoryLockoutStore struct {
+	mut       sync.RWMutex
+	states    map[string]LockoutState
+	lastPrune time.Time
+}
+
+func NewMemoryLockoutStore() *MemoryLockoutStore {
+	return &MemoryLockoutStore{states: make(map[string]LockoutState)}
+}
+
+func (ms *MemoryLockoutStore) Get(user string) (LockoutState, bool, error) {
+	ms.mut.RLock()
+	defer ms.mut.RUnlock()
+	state, ok := ms.states[user]
+	return state, ok, nil
+}
+
+func (ms *MemoryLockoutStore) Put(user string, state LockoutState) error {
+	ms.mut.Lock()
+	defer ms.mut.Unlock()
+	if ms.states == nil {
+		ms.states = make(map[string]LockoutState)
+	}
+	ms.states[user] = state
+	ms.pruneLocked(time.Now(), false)
+	return nil
+}
+
+// pruneLocked 删除过期的失败记录, force 为 false 时间隔 lockoutPruneInterval 执行一次.
+func (ms *MemoryLockoutStore) pruneLocked(now time.Time, force bool) (pruned bool) {
+	if !force && now.Sub(ms.lastPrune) < lockoutPruneInterval {
+		return false
+	}
+	ms.lastPrune = now
+	for user, state := range ms.states {
+		if state.Expired(now) {
+			delete(ms.states, user)
+			pruned = true
+		}
+	}
+	return pruned
+}
+
+func (ms *MemoryLockoutStore) Delete(user string) error {
+	ms.mut.Lock()
+	defer ms.mut.Unlock()
+	delete(ms.states, user)
+	return nil
+}
+
+// FileLockoutStore 以 JSON 文件保存的 LockoutStore, 重启后锁定仍然有效.
+// 仅持久化处于锁定状态的账户, 账户被锁定或解锁时通过临时文件与 rename 原子地写入;
+// 未达到阈值的失败次数只保存在内存中, 避免每次认证失败都写入文件.
+type FileLockoutStore struct {
+	filename string
+
+	mut sync.Mutex // 串行化写入
+	mem *MemoryLockoutStore
+}
+
+// NewFileLockoutStore 打开 filename, 文件不存在时将在首次修改时创建.
+func NewFileLockoutStore(filename string) (*FileLockoutStore, error) {
+	fs := &FileLockoutStore{
+		filename: filepath.Clean(filename),
+		mem:      NewMemoryLockoutStore(),
+	}
+
+	data, err := os.ReadFile(fs.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return fs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &fs.mem.states); err != nil {
+			return nil, fmt.Errorf("sshd: parse %s: %w", fs.filename, err)
+		}
+	}
+	return fs, nil
+}
+
+func (fs *FileLockoutStore) Get(user string) (LockoutState, bool, error) {
+	return fs.mem.Get(user)
+}
+
+func (fs *FileLockoutStore) Put(user string, state LockoutState) error {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+
+	now := time.Now()
+	if state.Expired(now) {
+		return fs.deleteLocked(user)
+	}
+	prev, _, _ := fs.mem.Get(user)
+	fs.mem.Put(user, state)
+	if !lockedAt(state, now) && !lockedAt(prev, now) {
+		return nil
+	}
+	return fs.save()
+}
+
+func (fs *FileLockoutStore) Delete(user string) error {
+	fs.mut.Lock()
+	defer fs.mut.Unlock()
+	return fs.deleteLocked(user)
+}
+
+func (fs *FileLockoutStore) deleteLocked(user string) error {
+	prev, ok, _ := fs.mem.Get(user)
+	if !ok {
+		return nil
+	}
+
+	fs.mem.Delete(user)
+	if !lockedAt(prev, time.Now()) {
+		return nil
+	}
+	return fs.save()
+}
+
+// save 写入处于锁定状态的账户
+func (fs *FileLockoutStore) save() error {
+	now := time.Now()
+	fs.mem.mut.Lock()
+	fs.mem.pruneLocked(now, true)
+	states := make(map[string]LockoutState)
+	for user, state := range fs.mem.states {
+		if lockedAt(state, now) {
+			states[user] = state
+		}
+	}
+	fs.mem.mut.Unlock()
+
+	data, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fs.filename, data, 0o600)
+}
+
+func lockedAt(state LockoutState, now time.Time) bool {
+	return now.Before(state.LockedUntil)
+}
+
+// writeFileAtomic 先写入同目录下的临时文件, 再 rename 覆盖 filename.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
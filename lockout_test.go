@@ -0,0 +1,118 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMemoryLockoutStorePrunesExpired(t *testing.T) {
+	store := NewMemoryLockoutStore()
+	lockout := &AccountLockout{Threshold: 3, Window: time.Millisecond * 20, Cooldown: time.Minute, Store: store}
+
+	for i := 0; i < 100; i++ {
+		if _, err := lockout.Fail(fmt.Sprintf("user%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := lockout.Fail("alice"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	time.Sleep(time.Millisecond * 30)
+	store.mut.Lock()
+	store.lastPrune = time.Time{}
+	store.mut.Unlock()
+	if _, err := lockout.Fail("bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	store.mut.RLock()
+	n := len(store.states)
+	store.mut.RUnlock()
+	if n != 2 {
+		t.Errorf("want alice (locked) and bob left, got %d states", n)
+	}
+	if err := lockout.Check("alice"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("want alice locked, got %v", err)
+	}
+}
+
+func TestFileLockoutStorePersistsOnlyLocked(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "lockout.json")
+	store, err := NewFileLockoutStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockout := &AccountLockout{Threshold: 2, Window: time.Minute, Cooldown: time.Minute, Store: store}
+
+	for i := 0; i < 50; i++ {
+		if _, err := lockout.Fail(fmt.Sprintf("user%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filename); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("below threshold failures written to file: %v", err)
+	}
+
+	if _, err := lockout.Fail("user1"); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileLockoutStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.mem.states) != 1 {
+		t.Errorf("want only the locked account in file, got %v", reopened.mem.states)
+	}
+	lockout.Store = reopened
+	if err := lockout.Check("user1"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("want user1 locked after reopen, got %v", err)
+	}
+
+	if err := lockout.Unlock("user1"); err != nil {
+		t.Fatal(err)
+	}
+	if reopened, err = NewFileLockoutStore(filename); err != nil || len(reopened.mem.states) != 0 {
+		t.Errorf("want empty file after unlock, got %v, %v", reopened.mem.states, err)
+	}
+}
+
+func TestLockoutIgnoresUnconfiguredMethods(t *testing.T) {
+	lockout := NewAccountLockout(2, time.Minute, time.Minute)
+	lockout.Methods = []string{AuthMethodPassword, AuthMethodPublicKey}
+	srv := NewServer(NewServeMux(), WithAccountLockout(lockout))
+
+	// 仅支持公钥认证
+	conf := srv.newConnAuth(context.Background()).wrap(&ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, errors.New("unknown key")
+		},
+	})
+	meta := testConnMetadata{user: "alice"}
+
+	// golang.org/x/crypto/ssh 对未配置的认证方式同样调用 AuthLogCallback
+	for i := 0; i < 3; i++ {
+		conf.AuthLogCallback(meta, AuthMethodPassword, errors.New("ssh: password auth not configured"))
+		conf.AuthLogCallback(meta, "hostbased", errors.New("ssh: unknown method \"hostbased\""))
+	}
+	if err := lockout.Check("alice"); err != nil {
+		t.Errorf("password attempts on a publickey-only server locked the account: %v", err)
+	}
+
+	// 配置了的认证方式仍然计入
+	for i := 0; i < 2; i++ {
+		conf.AuthLogCallback(meta, AuthMethodPublicKey, errors.New("unknown key"))
+	}
+	if err := lockout.Check("alice"); !errors.Is(err, ErrAccountLocked) {
+		t.Errorf("want account locked after publickey failures, got %v", err)
+	}
+}
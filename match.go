@@ -0,0 +1,77 @@
+package sshd
+
+import (
+	"net"
+	"strings"
+)
+
+// matchPattern 通配符匹配, '*' 匹配任意个字符, '?' 匹配单个字符, 与 OpenSSH 的 match_pattern 一致.
+func matchPattern(s, pattern string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// 合并连续的 '*'
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(s[i:], pattern) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		s, pattern = s[1:], pattern[1:]
+	}
+	return len(s) == 0
+}
+
+// matchAddr 匹配 IP 地址, pattern 可以是 CIDR 或通配符.
+func matchAddr(ip, pattern string) bool {
+	if strings.Contains(pattern, "/") {
+		_, ipNet, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return false
+		}
+		addr := net.ParseIP(ip)
+		return addr != nil && ipNet.Contains(addr)
+	}
+	return matchPattern(ip, pattern)
+}
+
+// matchList 匹配以逗号分隔的列表, 以 '!' 开头的为否定项.
+// 命中否定项时返回 false, 否则任一项命中即返回 true.
+func matchList(s, list string, match func(s, pattern string) bool) bool {
+	var matched bool
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+
+		negated := pattern[0] == '!'
+		if negated {
+			pattern = pattern[1:]
+		}
+		if match(s, pattern) {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
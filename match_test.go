@@ -0,0 +1,80 @@
+package sshd
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	cases := []struct {
+		s, pattern string
+		want       bool
+	}{
+		{"git", "git", true},
+		{"git", "gi", false},
+		{"gi", "git", false},
+		{"", "", true},
+		{"", "*", true},
+		{"", "?", false},
+		{"git", "*", true},
+		{"git", "g*", true},
+		{"git", "*t", true},
+		{"git", "g**t", true},
+		{"git", "*i*", true},
+		{"git", "g?t", true},
+		{"gt", "g?t", false},
+		{"git", "???", true},
+		{"git", "????", false},
+		{"admin-1", "admin-?", true},
+		{"192.168.1.7", "192.168.1.*", true},
+		{"192.168.10.7", "192.168.1.*", false},
+		{"Git", "git", false},
+	}
+	for _, c := range cases {
+		if got := matchPattern(c.s, c.pattern); got != c.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", c.s, c.pattern, got, c.want)
+		}
+	}
+}
+
+func TestMatchAddr(t *testing.T) {
+	cases := []struct {
+		ip, pattern string
+		want        bool
+	}{
+		{"10.1.2.3", "10.0.0.0/8", true},
+		{"11.1.2.3", "10.0.0.0/8", false},
+		{"2001:db8::1", "2001:db8::/32", true},
+		{"10.1.2.3", "2001:db8::/32", false},
+		{"10.1.2.3", "10.0.0.0/33", false},
+		{"not-an-ip", "10.0.0.0/8", false},
+		{"10.1.2.3", "10.1.2.*", true},
+		{"10.1.2.3", "10.1.2.3", true},
+	}
+	for _, c := range cases {
+		if got := matchAddr(c.ip, c.pattern); got != c.want {
+			t.Errorf("matchAddr(%q, %q) = %v, want %v", c.ip, c.pattern, got, c.want)
+		}
+	}
+}
+
+func TestMatchList(t *testing.T) {
+	cases := []struct {
+		s, list string
+		want    bool
+	}{
+		{"10.1.2.3", "10.0.0.0/8", true},
+		{"10.1.2.3", "192.168.0.0/16, 10.0.0.0/8", true},
+		{"10.1.2.3", "192.168.0.0/16", false},
+		// 否定项优先, 与顺序无关
+		{"10.1.2.3", "10.0.0.0/8,!10.1.2.3", false},
+		{"10.1.2.3", "!10.1.2.3,10.0.0.0/8", false},
+		{"10.1.2.4", "!10.1.2.3,10.0.0.0/8", true},
+		// 仅有否定项时没有命中
+		{"10.1.2.4", "!10.1.2.3", false},
+		{"10.1.2.3", "", false},
+		{"10.1.2.3", " , ,10.1.2.*", true},
+	}
+	for _, c := range cases {
+		if got := matchList(c.s, c.list, matchAddr); got != c.want {
+			t.Errorf("matchList(%q, %q) = %v, want %v", c.s, c.list, got, c.want)
+		}
+	}
+}
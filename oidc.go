@@ -0,0 +1,648 @@
+package sshd
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	DefaultDeviceFlowTimeout = time.Minute * 5
+	deviceCodeGrantType      = "urn:ietf:params:oauth:grant-type:device_code"
+	maxOIDCResponseSize      = 1 << 20
+	jwksMinRefreshInterval   = time.Minute
+)
+
+// deviceFlowIntervalUnit device flow 中 interval 的单位, 测试中缩短
+var deviceFlowIntervalUnit = time.Second
+
+var (
+	ErrDeviceFlowDenied  = errors.New("sshd: device authorization denied")
+	ErrDeviceFlowExpired = errors.New("sshd: device code expired")
+	ErrInvalidIDToken    = errors.New("sshd: invalid id token")
+)
+
+// IDTokenClaims ID token 中的 claims
+type IDTokenClaims map[string]interface{}
+
+// String 获取字符串类型的 claim
+func (c IDTokenClaims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings 获取字符串数组类型的 claim, 如 groups.
+func (c IDTokenClaims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// DeviceFlowAuth 通过 OAuth 2.0 device authorization grant (RFC 8628) 登录的交互式认证:
+// 向客户端展示验证地址与用户码, 轮询 token endpoint, 校验 ID token 后将 claims 转换为 ssh.Permissions.
+//
+// 未设置 DeviceAuthorizationURL, TokenURL, JWKSURL 时, 通过 Issuer 的
+// /.well-known/openid-configuration 获取.
+type DeviceFlowAuth struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// Scopes 为空时为 "openid"
+	Scopes []string
+
+	DeviceAuthorizationURL string
+	TokenURL               string
+	JWKSURL                string
+
+	// UsernameClaim 须与 SSH 用户名一致的 claim, 默认为 "preferred_username", 为 "-" 时不校验.
+	UsernameClaim string
+
+	// ClaimsToPermissions 将 claims 转换为 ssh.Permissions, 返回 error 则认证失败.
+	// 为 nil 时以 sub, UsernameClaim 与 groups 创建 Principal.
+	ClaimsToPermissions func(ctx context.Context, conn ssh.ConnMetadata, claims IDTokenClaims) (*ssh.Permissions, error)
+
+	// Timeout 等待用户完成授权的最长时间, 默认为 DefaultDeviceFlowTimeout 与 device code 有效期的较小值.
+	Timeout    time.Duration
+	HTTPClient *http.Client
+
+	// mut 仅保护以下字段, 不在 HTTP 请求期间持有
+	mut         sync.Mutex
+	endpoints   *oidcEndpoints
+	jwks        map[string]crypto.PublicKey
+	jwksFetched time.Time
+	// jwksFetching 正在获取 JWKS 时不为 nil, 获取完成后关闭
+	jwksFetching chan struct{}
+}
+
+// oidcEndpoints 配置或通过 discovery 获取的 endpoint
+type oidcEndpoints struct {
+	deviceAuthorization string
+	token               string
+	jwks                string
+}
+
+var (
+	_ Authenticator     = &DeviceFlowAuth{}
+	_ AuthMethodChecker = &DeviceFlowAuth{}
+)
+
+// PublicKey implements Authenticator.PublicKey
+func (da *DeviceFlowAuth) PublicKey(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	return nil, ErrAuthNotSupported
+}
+
+// Password implements Authenticator.Password
+func (da *DeviceFlowAuth) Password(ctx context.Context, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	return nil, ErrAuthNotSupported
+}
+
+// SupportsAuthMethod implements AuthMethodChecker
+func (da *DeviceFlowAuth) SupportsAuthMethod(method string) bool {
+	return method == AuthMethodKeyboardInteractive
+}
+
+// KeyboardInteractive implements Authenticator.KeyboardInteractive
+func (da *DeviceFlowAuth) KeyboardInteractive(ctx context.Context, conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	endpoints, err := da.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := da.authorizeDevice(ctx, endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	verificationURI := device.VerificationURI
+	if len(verificationURI) == 0 {
+		verificationURI = device.VerificationURL
+	}
+	instruction := fmt.Sprintf("To sign in, visit %s and enter the code %s\n", verificationURI, device.UserCode)
+	if len(device.VerificationURIComplete) > 0 {
+		instruction += fmt.Sprintf("or open %s\n", device.VerificationURIComplete)
+	}
+	if _, err := client(conn.User(), instruction, nil, nil); err != nil {
+		return nil, err
+	}
+
+	timeout := da.Timeout
+	if timeout <= 0 {
+		timeout = DefaultDeviceFlowTimeout
+	}
+	if expires := time.Duration(device.ExpiresIn) * time.Second; expires > 0 && expires < timeout {
+		timeout = expires
+	}
+	pollCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	idToken, err := da.pollToken(pollCtx, endpoints, device)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := da.verifyIDToken(ctx, endpoints, idToken)
+	if err != nil {
+		return nil, err
+	}
+	return da.permissions(ctx, conn, claims)
+}
+
+func (da *DeviceFlowAuth) permissions(ctx context.Context, conn ssh.ConnMetadata, claims IDTokenClaims) (*ssh.Permissions, error) {
+	usernameClaim := da.UsernameClaim
+	if len(usernameClaim) == 0 {
+		usernameClaim = "preferred_username"
+	}
+	if usernameClaim != "-" && claims.String(usernameClaim) != conn.User() {
+		return nil, fmt.Errorf("sshd: claim %s %q does not match user %q", usernameClaim, claims.String(usernameClaim), conn.User())
+	}
+
+	if da.ClaimsToPermissions != nil {
+		return da.ClaimsToPermissions(ctx, conn, claims)
+	}
+	p := &Principal{
+		UserID: claims.String("sub"),
+		User:   conn.User(),
+		Groups: claims.Strings("groups"),
+	}
+	return p.Permissions()
+}
+
+type deviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURL         string `json:"verification_url"` // 部分实现使用的非标准字段
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func (da *DeviceFlowAuth) authorizeDevice(ctx context.Context, endpoints *oidcEndpoints) (*deviceAuthorization, error) {
+	scopes := da.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+	form := url.Values{
+		"client_id": {da.ClientID},
+		"scope":     {strings.Join(scopes, " ")},
+	}
+
+	var device deviceAuthorization
+	status, err := da.postForm(ctx, endpoints.deviceAuthorization, form, &device)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK || len(device.DeviceCode) == 0 {
+		return nil, fmt.Errorf("sshd: device authorization failed, status %d", status)
+	}
+	return &device, nil
+}
+
+func (da *DeviceFlowAuth) pollToken(ctx context.Context, endpoints *oidcEndpoints, device *deviceAuthorization) (string, error) {
+	interval := time.Duration(device.Interval) * deviceFlowIntervalUnit
+	if interval <= 0 {
+		interval = 5 * deviceFlowIntervalUnit
+	}
+	form := url.Values{
+		"grant_type":  {deviceCodeGrantType},
+		"device_code": {device.DeviceCode},
+		"client_id":   {da.ClientID},
+	}
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", ErrDeviceFlowExpired
+			}
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+
+		var token tokenResponse
+		if _, err := da.postForm(ctx, endpoints.token, form, &token); err != nil {
+			return "", err
+		}
+
+		switch token.Error {
+		case "":
+			if len(token.IDToken) == 0 {
+				return "", fmt.Errorf("%w: missing id_token", ErrInvalidIDToken)
+			}
+			return token.IDToken, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * deviceFlowIntervalUnit
+		case "access_denied":
+			return "", ErrDeviceFlowDenied
+		case "expired_token":
+			return "", ErrDeviceFlowExpired
+		default:
+			return "", fmt.Errorf("sshd: token endpoint error %s: %s", token.Error, token.ErrorDescription)
+		}
+		timer.Reset(interval)
+	}
+}
+
+// discover 返回 endpoint, 未设置的 endpoint 通过 Issuer 获取
+func (da *DeviceFlowAuth) discover(ctx context.Context) (*oidcEndpoints, error) {
+	da.mut.Lock()
+	endpoints := da.endpoints
+	da.mut.Unlock()
+	if endpoints != nil {
+		return endpoints, nil
+	}
+
+	endpoints = &oidcEndpoints{
+		deviceAuthorization: da.DeviceAuthorizationURL,
+		token:               da.TokenURL,
+		jwks:                da.JWKSURL,
+	}
+	if len(endpoints.deviceAuthorization) == 0 || len(endpoints.token) == 0 || len(endpoints.jwks) == 0 {
+		if len(da.Issuer) == 0 {
+			return nil, errors.New("sshd: device flow requires Issuer or endpoint URLs")
+		}
+
+		var config struct {
+			Issuer                      string `json:"issuer"`
+			DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+			TokenEndpoint               string `json:"token_endpoint"`
+			JWKSURI                     string `json:"jwks_uri"`
+		}
+		discoveryURL := strings.TrimSuffix(da.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := da.getJSON(ctx, discoveryURL, &config); err != nil {
+			return nil, err
+		}
+		if config.Issuer != da.Issuer {
+			return nil, fmt.Errorf("sshd: issuer mismatch, expected %q got %q", da.Issuer, config.Issuer)
+		}
+
+		if len(endpoints.deviceAuthorization) == 0 {
+			endpoints.deviceAuthorization = config.DeviceAuthorizationEndpoint
+		}
+		if len(endpoints.token) == 0 {
+			endpoints.token = config.TokenEndpoint
+		}
+		if len(endpoints.jwks) == 0 {
+			endpoints.jwks = config.JWKSURI
+		}
+	}
+
+	// 并发的 discover 可能同时请求, 以先完成的为准
+	da.mut.Lock()
+	defer da.mut.Unlock()
+	if da.endpoints == nil {
+		da.endpoints = endpoints
+	}
+	return da.endpoints, nil
+}
+
+// verifyIDToken 校验 ID token 的签名, iss, aud, exp 与 nbf, 返回其 claims.
+func (da *DeviceFlowAuth) verifyIDToken(ctx context.Context, endpoints *oidcEndpoints, idToken string) (IDTokenClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed jwt", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	key, err := da.signingKey(ctx, endpoints.jwks, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims IDTokenClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := da.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (da *DeviceFlowAuth) validateClaims(claims IDTokenClaims) error {
+	const leeway = time.Minute
+	now := time.Now()
+
+	if len(da.Issuer) > 0 && claims.String("iss") != da.Issuer {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, claims.String("iss"))
+	}
+
+	var audOK bool
+	for _, aud := range claims.Strings("aud") {
+		if aud == da.ClientID {
+			audOK = true
+			break
+		}
+	}
+	if !audOK {
+		return fmt.Errorf("%w: audience does not contain %q", ErrInvalidIDToken, da.ClientID)
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing exp", ErrInvalidIDToken)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return fmt.Errorf("%w: token expired", ErrInvalidIDToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(leeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("%w: token not yet valid", ErrInvalidIDToken)
+	}
+	return nil
+}
+
+// signingKey 获取 kid 对应的公钥, 未找到时重新获取 JWKS (限制频率), 同一时间只有一个请求.
+func (da *DeviceFlowAuth) signingKey(ctx context.Context, jwksURL, kid string) (crypto.PublicKey, error) {
+	for {
+		da.mut.Lock()
+		if key, ok := da.lookupJWK(kid); ok {
+			da.mut.Unlock()
+			return key, nil
+		}
+		if fetching := da.jwksFetching; fetching != nil {
+			da.mut.Unlock()
+			select {
+			case <-fetching:
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if time.Since(da.jwksFetched) < jwksMinRefreshInterval {
+			da.mut.Unlock()
+			return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidIDToken, kid)
+		}
+		fetching := make(chan struct{})
+		da.jwksFetching = fetching
+		da.mut.Unlock()
+
+		keys, err := da.fetchJWKS(ctx, jwksURL)
+
+		da.mut.Lock()
+		da.jwksFetching = nil
+		close(fetching)
+		if err != nil {
+			da.mut.Unlock()
+			return nil, err
+		}
+		da.jwks = keys
+		da.jwksFetched = time.Now()
+		key, ok := da.lookupJWK(kid)
+		da.mut.Unlock()
+
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown key id %q", ErrInvalidIDToken, kid)
+		}
+		return key, nil
+	}
+}
+
+func (da *DeviceFlowAuth) fetchJWKS(ctx context.Context, jwksURL string) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := da.getJSON(ctx, jwksURL, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// lookupJWK kid 为空且仅有一个公钥时使用该公钥
+func (da *DeviceFlowAuth) lookupJWK(kid string) (crypto.PublicKey, bool) {
+	if key, ok := da.jwks[kid]; ok {
+		return key, true
+	}
+	if len(kid) == 0 && len(da.jwks) == 1 {
+		for _, key := range da.jwks {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (da *DeviceFlowAuth) httpClient() *http.Client {
+	if da.HTTPClient != nil {
+		return da.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (da *DeviceFlowAuth) getJSON(ctx context.Context, rawURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := da.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sshd: GET %s: status %d", rawURL, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseSize)).Decode(v)
+}
+
+// postForm 提交表单并解析 JSON 响应, 非 2xx 的响应也会尝试解析, 以获取 OAuth 2.0 的 error.
+func (da *DeviceFlowAuth) postForm(ctx context.Context, rawURL string, form url.Values, v interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(da.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(da.ClientID), url.QueryEscape(da.ClientSecret))
+	}
+
+	resp, err := da.httpClient().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOIDCResponseSize))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return resp.StatusCode, fmt.Errorf("sshd: POST %s: status %d: %w", rawURL, resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	return nil
+}
+
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidIDToken, alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] != 'R' {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, signature); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+		}
+		return nil
+
+	case *ecdsa.PublicKey:
+		if alg[0] != 'E' {
+			break
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("%w: invalid signature length", ErrInvalidIDToken)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("%w: signature verification failed", ErrInvalidIDToken)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: alg %q does not match key type %T", ErrInvalidIDToken, alg, key)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (jwk *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		data, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(data), nil
+	}
+
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decode(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, err := decode(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+}
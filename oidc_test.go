@@ -0,0 +1,306 @@
+package sshd
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// testOIDCProvider 模拟 OIDC provider 的 discovery, device authorization, token 与 JWKS endpoint
+type testOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mut sync.Mutex
+	// tokenErrors 依次作为 token endpoint 返回的 error, 为空字符串或用完时返回 idToken
+	tokenErrors []string
+	tokenCalls  int
+	idToken     string
+}
+
+func newTestOIDCProvider(t *testing.T) *testOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testOIDCProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]string{
+			"issuer":                        p.URL,
+			"device_authorization_endpoint": p.URL + "/device",
+			"token_endpoint":                p.URL + "/token",
+			"jwks_uri":                      p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("client_id") != "sshd" {
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+			return
+		}
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"device_code":      "device-code",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": p.URL + "/verify",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != deviceCodeGrantType || r.FormValue("device_code") != "device-code" {
+			writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		p.mut.Lock()
+		defer p.mut.Unlock()
+		p.tokenCalls++
+		if len(p.tokenErrors) > 0 {
+			tokenErr := p.tokenErrors[0]
+			p.tokenErrors = p.tokenErrors[1:]
+			if len(tokenErr) > 0 {
+				writeTestJSON(w, http.StatusBadRequest, map[string]string{"error": tokenErr})
+				return
+			}
+		}
+		writeTestJSON(w, http.StatusOK, map[string]string{"id_token": p.idToken})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeTestJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// claims 返回有效的 claims, 可在此基础上修改
+func (p *testOIDCProvider) claims() map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss":                p.URL,
+		"aud":                "sshd",
+		"sub":                "u-1001",
+		"preferred_username": "alice",
+		"groups":             []string{"dev", "ops"},
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+	}
+}
+
+func (p *testOIDCProvider) setToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}, tokenErrors ...string) {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test-key", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.mut.Lock()
+	p.idToken = signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	p.tokenErrors = tokenErrors
+	p.tokenCalls = 0
+	p.mut.Unlock()
+}
+
+func writeTestJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+type testConnMetadata struct {
+	user string
+}
+
+func (m testConnMetadata) User() string          { return m.user }
+func (m testConnMetadata) SessionID() []byte     { return []byte("session") }
+func (m testConnMetadata) ClientVersion() []byte { return []byte("SSH-2.0-test") }
+func (m testConnMetadata) ServerVersion() []byte { return []byte("SSH-2.0-sshd") }
+func (m testConnMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50022}
+}
+func (m testConnMetadata) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+}
+
+func useFastDeviceFlow(t *testing.T) {
+	unit := deviceFlowIntervalUnit
+	deviceFlowIntervalUnit = time.Millisecond
+	t.Cleanup(func() { deviceFlowIntervalUnit = unit })
+}
+
+func TestDeviceFlowAuth(t *testing.T) {
+	useFastDeviceFlow(t)
+	p := newTestOIDCProvider(t)
+	p.setToken(t, p.key, p.claims(), "authorization_pending", "slow_down", "authorization_pending")
+
+	da := &DeviceFlowAuth{Issuer: p.URL, ClientID: "sshd"}
+	var instruction string
+	challenge := func(user, inst string, questions []string, echos []bool) ([]string, error) {
+		instruction = inst
+		return nil, nil
+	}
+
+	perms, err := da.KeyboardInteractive(context.Background(), testConnMetadata{user: "alice"}, challenge)
+	if err != nil {
+		t.Fatalf("device flow: %v", err)
+	}
+	if !strings.Contains(instruction, p.URL+"/verify") || !strings.Contains(instruction, "ABCD-EFGH") {
+		t.Errorf("unexpected instruction %q", instruction)
+	}
+	if p.tokenCalls != 4 {
+		t.Errorf("want 4 token requests, got %d", p.tokenCalls)
+	}
+
+	principal, err := PrincipalFromPermissions(perms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.UserID != "u-1001" || principal.User != "alice" || !principal.InGroup("ops") {
+		t.Errorf("unexpected principal %+v", principal)
+	}
+}
+
+func TestDeviceFlowAuthClaimsToPermissions(t *testing.T) {
+	useFastDeviceFlow(t)
+	p := newTestOIDCProvider(t)
+	claims := p.claims()
+	claims["email"] = "alice@example.com"
+	p.setToken(t, p.key, claims)
+
+	da := &DeviceFlowAuth{
+		Issuer:        p.URL,
+		ClientID:      "sshd",
+		UsernameClaim: "-",
+		ClaimsToPermissions: func(ctx context.Context, conn ssh.ConnMetadata, claims IDTokenClaims) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{"email": claims.String("email")}}, nil
+		},
+	}
+	challenge := func(string, string, []string, []bool) ([]string, error) { return nil, nil }
+
+	perms, err := da.KeyboardInteractive(context.Background(), testConnMetadata{user: "root"}, challenge)
+	if err != nil {
+		t.Fatalf("device flow: %v", err)
+	}
+	if perms.Extensions["email"] != "alice@example.com" {
+		t.Errorf("unexpected permissions %+v", perms)
+	}
+}
+
+func TestDeviceFlowAuthRejected(t *testing.T) {
+	useFastDeviceFlow(t)
+	p := newTestOIDCProvider(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name        string
+		user        string
+		key         *rsa.PrivateKey
+		modify      func(claims map[string]interface{})
+		tokenErrors []string
+		want        error
+		wantMsg     string
+	}{
+		{name: "denied", tokenErrors: []string{"authorization_pending", "access_denied"}, want: ErrDeviceFlowDenied},
+		{name: "expired token", tokenErrors: []string{"expired_token"}, want: ErrDeviceFlowExpired},
+		{name: "username mismatch", user: "bob", wantMsg: "does not match user"},
+		{name: "bad signature", key: otherKey, want: ErrInvalidIDToken},
+		{name: "bad audience", modify: func(c map[string]interface{}) { c["aud"] = []string{"other"} }, want: ErrInvalidIDToken},
+		{name: "bad issuer", modify: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, want: ErrInvalidIDToken},
+		{name: "expired", modify: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, want: ErrInvalidIDToken},
+		{name: "missing exp", modify: func(c map[string]interface{}) { delete(c, "exp") }, want: ErrInvalidIDToken},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key, user := c.key, c.user
+			if key == nil {
+				key = p.key
+			}
+			if len(user) == 0 {
+				user = "alice"
+			}
+			claims := p.claims()
+			if c.modify != nil {
+				c.modify(claims)
+			}
+			p.setToken(t, key, claims, c.tokenErrors...)
+
+			da := &DeviceFlowAuth{Issuer: p.URL, ClientID: "sshd"}
+			challenge := func(string, string, []string, []bool) ([]string, error) { return nil, nil }
+			perms, err := da.KeyboardInteractive(context.Background(), testConnMetadata{user: user}, challenge)
+			if err == nil {
+				t.Fatalf("want error, got permissions %+v", perms)
+			}
+			if c.want != nil && !errors.Is(err, c.want) {
+				t.Errorf("want %v, got %v", c.want, err)
+			}
+			if len(c.wantMsg) > 0 && !strings.Contains(err.Error(), c.wantMsg) {
+				t.Errorf("want error containing %q, got %v", c.wantMsg, err)
+			}
+		})
+	}
+}
+
+func TestDeviceFlowAuthConcurrent(t *testing.T) {
+	useFastDeviceFlow(t)
+	p := newTestOIDCProvider(t)
+	p.setToken(t, p.key, p.claims())
+
+	da := &DeviceFlowAuth{Issuer: p.URL, ClientID: "sshd"}
+	challenge := func(string, string, []string, []bool) ([]string, error) { return nil, nil }
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := da.KeyboardInteractive(context.Background(), testConnMetadata{user: "alice"}, challenge)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("device flow: %v", err)
+		}
+	}
+}
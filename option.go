@@ -20,12 +20,171 @@ func WithConnContext(fn ConnContext) Option {
 	}
 }
 
+// WithBaseContext 设置为 listener 创建基础 context 的函数
+func WithBaseContext(fn BaseContext) Option {
+	return func(srv *Server) {
+		srv.BaseContext = fn
+	}
+}
+
 func WithGetSshServerConfig(fn GetSshServerConfig) Option {
 	return func(srv *Server) {
 		srv.GetSshServerConfig = fn
 	}
 }
 
+// WithAuthenticator 设置 Server 的认证器, 多个 Authenticator 将按顺序组成 AuthChain.
+func WithAuthenticator(auths ...Authenticator) Option {
+	return func(srv *Server) {
+		if len(auths) == 1 {
+			srv.Authenticator = auths[0]
+			return
+		}
+		srv.Authenticator = NewAuthChain(auths...)
+	}
+}
+
+// WithPublicKeyCallback 设置可获取连接 context 的公钥认证回调
+func WithPublicKeyCallback(fn PublicKeyCallback) Option {
+	return func(srv *Server) {
+		srv.PublicKeyCallback = fn
+	}
+}
+
+// WithPasswordCallback 设置可获取连接 context 的密码认证回调
+func WithPasswordCallback(fn PasswordCallback) Option {
+	return func(srv *Server) {
+		srv.PasswordCallback = fn
+	}
+}
+
+// WithKeyboardInteractiveCallback 设置可获取连接 context 的交互式认证回调
+func WithKeyboardInteractiveCallback(fn KeyboardInteractiveCallback) Option {
+	return func(srv *Server) {
+		srv.KeyboardInteractiveCallback = fn
+	}
+}
+
+// WithAuthEventCallback 设置认证事件的回调, 可用于审计登录.
+func WithAuthEventCallback(fn AuthEventCallback) Option {
+	return func(srv *Server) {
+		srv.AuthEventCallback = fn
+	}
+}
+
+// WithLoginGraceTime 设置握手与认证的总时长上限, 超时后断开 TCP 连接.
+func WithLoginGraceTime(duration time.Duration) Option {
+	return func(srv *Server) {
+		srv.LoginGraceTime = duration
+	}
+}
+
+// WithMaxAuthTries 设置每个连接的最大认证次数, 为负数时不限制.
+func WithMaxAuthTries(n int) Option {
+	return func(srv *Server) {
+		srv.MaxAuthTries = n
+	}
+}
+
+// WithAccessList 设置访问控制列表, 运行时可通过 Server.SetAccessList 更新.
+func WithAccessList(al *AccessList) Option {
+	return func(srv *Server) {
+		srv.SetAccessList(al)
+	}
+}
+
+// WithAccountLockout 设置账户锁定策略, 可通过 AccountLockout.Unlock 手动解锁.
+func WithAccountLockout(lockout *AccountLockout) Option {
+	return func(srv *Server) {
+		srv.AccountLockout = lockout
+	}
+}
+
+// WithKeyBlocklist 设置公钥黑名单, 出示黑名单中的公钥将产生认证失败的 AuthEvent.
+func WithKeyBlocklist(kb *KeyBlocklist) Option {
+	return func(srv *Server) {
+		srv.KeyBlocklist = kb
+	}
+}
+
+// WithAccessSchedule 按用户或组限制登录的时间段
+func WithAccessSchedule(as *AccessSchedule) Option {
+	return func(srv *Server) {
+		srv.AccessSchedule = as
+	}
+}
+
+// WithMaxConnections 设置最大并发连接数
+func WithMaxConnections(n int) Option {
+	return func(srv *Server) {
+		srv.MaxConnections = n
+	}
+}
+
+// WithMaxConnectionsPerIP 设置每个客户端 IP 的最大并发连接数
+func WithMaxConnectionsPerIP(n int) Option {
+	return func(srv *Server) {
+		srv.MaxConnectionsPerIP = n
+	}
+}
+
+// WithMaxSessionsPerUser 设置每个用户的最大并发连接数
+func WithMaxSessionsPerUser(n int) Option {
+	return func(srv *Server) {
+		srv.MaxSessionsPerUser = n
+	}
+}
+
+// WithMaxStartups 设置未认证连接的随机早期丢弃, 与 OpenSSH 的 MaxStartups start:rate:full 一致.
+// 参数不合法 (如 start 不大于 0) 时 panic, 否则将丢弃全部连接.
+func WithMaxStartups(start, rate, full int) Option {
+	ms := &MaxStartups{Start: start, Rate: rate, Full: full}
+	if err := ms.validate(); err != nil {
+		panic(err)
+	}
+	return func(srv *Server) {
+		srv.MaxStartups = ms
+	}
+}
+
+// WithShutdownMessage 设置 Shutdown 时写入每个 session 的 stderr 的消息
+func WithShutdownMessage(msg string) Option {
+	return func(srv *Server) {
+		srv.ShutdownMessage = msg
+	}
+}
+
+// WithConnState 设置连接状态变化时的回调
+func WithConnState(fn ConnStateHook) Option {
+	return func(srv *Server) {
+		srv.ConnState = fn
+	}
+}
+
+// WithChannelIdleTimeout 设置 channel 无数据读写的时长上限, onlyWithoutChannels 为 true 时仅在没有打开的 channel 时计时.
+func WithChannelIdleTimeout(timeout time.Duration, onlyWithoutChannels bool) Option {
+	return func(srv *Server) {
+		srv.ChannelIdleTimeout = timeout
+		srv.IdleOnlyWithoutChannels = onlyWithoutChannels
+	}
+}
+
+// WithIdleWarning 设置空闲断开前提示的时长与消息, msg 为空时提示剩余的时间.
+func WithIdleWarning(before time.Duration, msg string) Option {
+	return func(srv *Server) {
+		srv.IdleWarning = before
+		srv.IdleWarningMessage = msg
+	}
+}
+
+// WithMaxDuration 设置连接与每个 session 的最长时间, 为 0 时不限制.
+func WithMaxDuration(connection, session time.Duration) Option {
+	return func(srv *Server) {
+		srv.MaxConnectionDuration = connection
+		srv.MaxSessionDuration = session
+	}
+}
+
 func WithErrLogger(logger *log.Logger) Option {
 	return func(srv *Server) {
 		srv.ErrLogger = logger
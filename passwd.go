@@ -0,0 +1,198 @@
+package sshd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrPasswordMismatch    = errors.New("sshd: password mismatch")
+	ErrUnsupportedPassword = errors.New("sshd: unsupported password hash")
+)
+
+// dummyBcryptHash 用户不存在时参与比较, 使耗时与存在的用户一致.
+var dummyBcryptHash = []byte("$2a$10$z0Xoh1VKFsxxjJUnTpU.veSKxKHU8vqj/hlXvwZVYwiGzx47S.XZC")
+
+// PasswordFile 类 htpasswd 的密码文件, 每行格式为 "user:hash", 以 # 开头的行为注释.
+// 支持 bcrypt ($2a$, $2b$, $2y$) 与 argon2id ($argon2id$v=19$m=...,t=...,p=...$salt$hash).
+// 每次认证前检查文件是否变化, 变化后自动重新加载.
+type PasswordFile struct {
+	// ErrLogger 输出重新加载时的错误, 默认为 log.Default, 可设置为 Server.ErrLogger
+	ErrLogger *log.Logger
+
+	reloader *fileReloader
+
+	mut    sync.RWMutex
+	hashes map[string]string
+}
+
+// NewPasswordFile 读取并解析密码文件
+func NewPasswordFile(filename string) (*PasswordFile, error) {
+	pf := &PasswordFile{}
+	pf.reloader = newFileReloader("password file", filename, pf.load)
+	if err := pf.Reload(); err != nil {
+		return nil, err
+	}
+	return pf, nil
+}
+
+// Reload 重新读取密码文件
+func (pf *PasswordFile) Reload() error {
+	return pf.reloader.reload()
+}
+
+func (pf *PasswordFile) load(data []byte) error {
+	hashes, err := parsePasswordFile(data)
+	if err != nil {
+		return err
+	}
+	pf.mut.Lock()
+	pf.hashes = hashes
+	pf.mut.Unlock()
+	return nil
+}
+
+// Verify 校验用户密码, 用户不存在或密码不匹配时返回 ErrPasswordMismatch.
+func (pf *PasswordFile) Verify(user string, password []byte) error {
+	pf.reloader.reloadIfChanged(pf.ErrLogger)
+
+	pf.mut.RLock()
+	hash, ok := pf.hashes[user]
+	pf.mut.RUnlock()
+
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyBcryptHash, password)
+		return ErrPasswordMismatch
+	}
+	return VerifyPasswordHash(hash, password)
+}
+
+// PasswordCallback 适配 ssh.ServerConfig.PasswordCallback 与 PasswordAuth.
+func (pf *PasswordFile) PasswordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	if err := pf.Verify(conn.User(), password); err != nil {
+		return nil, err
+	}
+	return &ssh.Permissions{}, nil
+}
+
+func parsePasswordFile(data []byte) (map[string]string, error) {
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || len(user) == 0 || len(hash) == 0 {
+			return nil, fmt.Errorf("line %d: invalid entry", lineNo)
+		}
+		if err := checkPasswordHash(hash); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		hashes[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// VerifyPasswordHash 以恒定时间比较密码与哈希值, 支持 bcrypt 与 argon2id.
+// 密码不匹配时返回 ErrPasswordMismatch, 哈希格式不支持时返回 ErrUnsupportedPassword.
+func VerifyPasswordHash(hash string, password []byte) error {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), password)
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrPasswordMismatch
+		}
+		return err
+
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2id(hash, password)
+	}
+	return ErrUnsupportedPassword
+}
+
+// argon2id 参数的上限, 避免一条异常的哈希在每次登录时占用过多的内存与 CPU.
+const (
+	maxArgon2Memory     = 1 << 20 // KiB, 即 1GiB
+	maxArgon2Iterations = 64
+)
+
+type argon2idHash struct {
+	memory     uint32
+	iterations uint32
+	threads    uint8
+	salt       []byte
+	key        []byte
+}
+
+// parseArgon2id 解析并校验 PHC 格式的 argon2id 哈希:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func parseArgon2id(hash string) (*argon2idHash, error) {
+	fields := strings.Split(hash, "$")
+	if len(fields) != 6 {
+		return nil, ErrUnsupportedPassword
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, ErrUnsupportedPassword
+	}
+
+	var h argon2idHash
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.threads); err != nil {
+		return nil, ErrUnsupportedPassword
+	}
+	if h.iterations < 1 || h.iterations > maxArgon2Iterations || h.threads < 1 ||
+		h.memory < 8*uint32(h.threads) || h.memory > maxArgon2Memory {
+		return nil, fmt.Errorf("%w: argon2id parameters m=%d,t=%d,p=%d out of range",
+			ErrUnsupportedPassword, h.memory, h.iterations, h.threads)
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(fields[4]); err != nil {
+		return nil, ErrUnsupportedPassword
+	}
+	h.key, err = base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(h.key) == 0 {
+		return nil, ErrUnsupportedPassword
+	}
+	return &h, nil
+}
+
+// checkPasswordHash 在加载时校验哈希, 目前仅校验 argon2id 的参数.
+func checkPasswordHash(hash string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		_, err := parseArgon2id(hash)
+		return err
+	}
+	return nil
+}
+
+func verifyArgon2id(hash string, password []byte) error {
+	h, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+
+	got := argon2.IDKey(password, h.salt, h.iterations, h.memory, h.threads, uint32(len(h.key)))
+	if subtle.ConstantTimeCompare(got, h.key) != 1 {
+		return ErrPasswordMismatch
+	}
+	return nil
+}
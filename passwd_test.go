@@ -0,0 +1,89 @@
+package sshd
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestParsePasswordFileArgon2idParams(t *testing.T) {
+	const salt, key = "c2FsdHNhbHQ", "a2V5a2V5a2V5a2V5"
+	cases := []struct {
+		params string
+		ok     bool
+	}{
+		{"m=65536,t=3,p=4", true},
+		{"m=65536,t=0,p=4", false},
+		{"m=65536,t=3,p=0", false},
+		{"m=4294967295,t=3,p=4", false},
+		{"m=65536,t=100000,p=4", false},
+		{"m=8,t=3,p=4", false},
+	}
+	for _, c := range cases {
+		line := "alice:$argon2id$v=19$" + c.params + "$" + salt + "$" + key + "\n"
+		_, err := parsePasswordFile([]byte(line))
+		if c.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.params, err)
+		}
+		if !c.ok && !errors.Is(err, ErrUnsupportedPassword) {
+			t.Errorf("%s: want ErrUnsupportedPassword, got %v", c.params, err)
+		}
+	}
+
+	// 运行时同样拒绝, 而非 panic
+	if err := VerifyPasswordHash("$argon2id$v=19$m=65536,t=0,p=4$"+salt+"$"+key, []byte("pw")); !errors.Is(err, ErrUnsupportedPassword) {
+		t.Errorf("want ErrUnsupportedPassword, got %v", err)
+	}
+}
+
+func TestPasswordFileReloadIfChanged(t *testing.T) {
+	hash := func(password string) string {
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(h)
+	}
+	filename := filepath.Join(t.TempDir(), "passwd")
+	if err := os.WriteFile(filename, []byte("alice:"+hash("old")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pf, err := NewPasswordFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	pf.ErrLogger = log.New(&logs, "", 0)
+
+	if err := pf.Verify("alice", []byte("old")); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// 修改时间精度可能较低, 同时改变文件大小
+	if err := os.WriteFile(filename, []byte("alice:"+hash("new")+"\nbob:"+hash("bob")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := pf.Verify("alice", []byte("new")); err != nil {
+		t.Fatalf("verify after change: %v", err)
+	}
+
+	// 加载失败时沿用旧的内容, 并输出到 ErrLogger
+	if err := os.WriteFile(filename, []byte("invalid\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chtimes(filename, time.Now(), time.Now().Add(time.Second))
+	if err := pf.Verify("bob", []byte("bob")); err != nil {
+		t.Fatalf("verify after invalid change: %v", err)
+	}
+	if !strings.Contains(logs.String(), "reload password file") {
+		t.Errorf("want reload error in ErrLogger, got %q", logs.String())
+	}
+}
@@ -0,0 +1,171 @@
+package sshd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// PermExtPrincipal Principal 序列化后存放于 ssh.Permissions.Extensions 的 key.
+	PermExtPrincipal = "sshd-principal"
+	// PermExtCertKeyID 证书认证通过后, 证书的 KeyId 存放于 ssh.Permissions.Extensions 的 key.
+	PermExtCertKeyID = "sshd-cert-key-id"
+)
+
+var ErrNoPrincipal = errors.New("sshd: no principal in permissions")
+
+// Principal 认证通过的身份, 在认证回调中通过 Attach 或 Permissions 附加到 ssh.Permissions,
+// 之后可在 ChannelChain.Principal 中获取, 无需再解析 Extensions.
+//
+// Fingerprint, KeyID 为空时, 由 Server 根据认证所用的公钥或证书填充.
+type Principal struct {
+	UserID      string   `json:"user_id,omitempty"`
+	User        string   `json:"user,omitempty"`
+	Fingerprint string   `json:"fingerprint,omitempty"`
+	KeyID       string   `json:"key_id,omitempty"`
+	Groups      []string `json:"groups,omitempty"`
+
+	// Attributes 自定义属性, 值须能被 encoding/json 序列化.
+	// 取值时通过 AttrString, AttrInt64, AttrBool 或 DecodeAttr 还原类型.
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Permissions 创建附加了 Principal 的 ssh.Permissions
+func (p *Principal) Permissions() (*ssh.Permissions, error) {
+	perms := &ssh.Permissions{
+		CriticalOptions: make(map[string]string),
+		Extensions:      make(map[string]string),
+	}
+	if err := p.Attach(perms); err != nil {
+		return nil, err
+	}
+	return perms, nil
+}
+
+// Attach 将 Principal 附加到 perms 上
+func (p *Principal) Attach(perms *ssh.Permissions) error {
+	if perms == nil {
+		return errors.New("sshd: nil permissions")
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if perms.Extensions == nil {
+		perms.Extensions = make(map[string]string)
+	}
+	perms.Extensions[PermExtPrincipal] = string(data)
+	return nil
+}
+
+// PrincipalFromPermissions 从 perms 中还原 Principal, 未附加时返回 ErrNoPrincipal.
+func PrincipalFromPermissions(perms *ssh.Permissions) (*Principal, error) {
+	if perms == nil || perms.Extensions == nil {
+		return nil, ErrNoPrincipal
+	}
+	data, ok := perms.Extensions[PermExtPrincipal]
+	if !ok {
+		return nil, ErrNoPrincipal
+	}
+
+	var p Principal
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// InGroup 判断是否属于 group
+func (p *Principal) InGroup(group string) bool {
+	for _, g := range p.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAttr 设置自定义属性
+func (p *Principal) SetAttr(key string, value interface{}) {
+	if p.Attributes == nil {
+		p.Attributes = make(map[string]interface{})
+	}
+	p.Attributes[key] = value
+}
+
+// Attr 获取自定义属性的原始值
+func (p *Principal) Attr(key string) (interface{}, bool) {
+	if p.Attributes == nil {
+		return nil, false
+	}
+	val, ok := p.Attributes[key]
+	return val, ok
+}
+
+func (p *Principal) AttrString(key string) string {
+	val, _ := p.Attr(key)
+	switch v := val.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+func (p *Principal) AttrInt64(key string) int64 {
+	val, _ := p.Attr(key)
+	switch v := val.(type) {
+	case json.Number:
+		n, _ := v.Int64()
+		return n
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case string:
+		n, _ := strconv.ParseInt(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+func (p *Principal) AttrBool(key string) bool {
+	val, _ := p.Attr(key)
+	switch v := val.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// AttrDuration 获取时长属性, 支持 "1h30m" 格式的字符串与纳秒数.
+func (p *Principal) AttrDuration(key string) time.Duration {
+	if d, err := time.ParseDuration(p.AttrString(key)); err == nil {
+		return d
+	}
+	return time.Duration(p.AttrInt64(key))
+}
+
+// DecodeAttr 将自定义属性解码到 v 中, v 须为指针, 适用于结构体等复杂类型.
+func (p *Principal) DecodeAttr(key string, v interface{}) error {
+	val, ok := p.Attr(key)
+	if !ok {
+		return errors.New("sshd: attribute " + key + " not found")
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
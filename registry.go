@@ -0,0 +1,105 @@
+package sshd
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"time"
+)
+
+var ErrConnNotFound = errors.New("sshd: connection not found")
+
+// ConnInfo 已认证连接的信息
+type ConnInfo struct {
+	// Listener 连接所属 listener 的名称, 见 Listener.String
+	Listener string
+
+	User          string
+	ClientIP      string
+	RemoteAddr    net.Addr
+	SessionID     string
+	ClientVersion string
+	ConnectedAt   time.Time
+
+	// Channels 已接受且未关闭的 channel 的类型, 如 "session"
+	Channels []string
+
+	// BytesRead, BytesWritten 连接读写的字节数, 包括 ssh 协议的开销
+	BytesRead    int64
+	BytesWritten int64
+}
+
+func (srv *Server) trackConn(sc *serverConn) {
+	srv.connsMut.Lock()
+	if srv.conns == nil {
+		srv.conns = make(map[*serverConn]struct{})
+	}
+	srv.conns[sc] = struct{}{}
+	srv.connsMut.Unlock()
+}
+
+func (srv *Server) untrackConn(sc *serverConn) {
+	srv.connsMut.Lock()
+	delete(srv.conns, sc)
+	srv.connsMut.Unlock()
+}
+
+// serverConns 符合 match 的连接, match 为 nil 时返回全部连接
+func (srv *Server) serverConns(match func(sc *serverConn) bool) []*serverConn {
+	srv.connsMut.Lock()
+	defer srv.connsMut.Unlock()
+
+	conns := make([]*serverConn, 0, len(srv.conns))
+	for sc := range srv.conns {
+		if match == nil || match(sc) {
+			conns = append(conns, sc)
+		}
+	}
+	return conns
+}
+
+// Connections 当前已认证的连接, 按建立时间排序.
+func (srv *Server) Connections() []ConnInfo {
+	conns := srv.serverConns(nil)
+	infos := make([]ConnInfo, 0, len(conns))
+	for _, sc := range conns {
+		infos = append(infos, sc.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+	return infos
+}
+
+// Disconnect 断开 session ID (十六进制, 同 ChannelChain.SessionID) 对应的连接,
+// reason 非空时先写入该连接的每个 session 的 stderr. 连接不存在时返回 ErrConnNotFound.
+func (srv *Server) Disconnect(sessionID, reason string) error {
+	conns := srv.serverConns(func(sc *serverConn) bool {
+		return sc.sessionID == sessionID
+	})
+	if len(conns) == 0 {
+		return ErrConnNotFound
+	}
+
+	return conns[0].disconnect(reason)
+}
+
+// DisconnectUser 断开 user 的全部连接, 返回断开的连接数.
+func (srv *Server) DisconnectUser(user, reason string) int {
+	conns := srv.serverConns(func(sc *serverConn) bool {
+		return sc.conn.User() == user
+	})
+	for _, sc := range conns {
+		_ = sc.disconnect(reason)
+	}
+	return len(conns)
+}
+
+func (sc *serverConn) disconnect(reason string) error {
+	if len(reason) > 0 {
+		sc.srv.logf("sshd: disconnecting %s of %s: %s", sc.conn.RemoteAddr(), sc.conn.User(), reason)
+	} else {
+		sc.srv.logf("sshd: disconnecting %s of %s", sc.conn.RemoteAddr(), sc.conn.User())
+	}
+	return sc.closeWithMessage(reason)
+}
@@ -0,0 +1,79 @@
+package sshd
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// stalledSession 向 stdout 持续写入, 客户端不读取时窗口耗尽而阻塞
+func stalledSession(written *atomic.Int64) HandlerFunc {
+	return func(cc *ChannelChain, conn *ssh.ServerConn, nc ssh.NewChannel) error {
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			return err
+		}
+		go ssh.DiscardRequests(reqs)
+		buf := make([]byte, 32<<10)
+		for {
+			n, err := ch.Write(buf)
+			written.Add(int64(n))
+			if err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// waitStalled 等待写入停止增长, 即客户端的窗口已耗尽
+func waitStalled(t *testing.T, written *atomic.Int64) {
+	t.Helper()
+	last := int64(-1)
+	for i := 0; i < 100; i++ {
+		time.Sleep(time.Millisecond * 50)
+		n := written.Load()
+		if n > 0 && n == last {
+			return
+		}
+		last = n
+	}
+	t.Fatal("session output never stalled")
+}
+
+func TestDisconnectStalledClient(t *testing.T) {
+	var written atomic.Int64
+	srv, addr := newTestServer(t, stalledSession(&written))
+	client := dialTestServer(t, addr)
+
+	// 不读取 session 的输出
+	if _, err := client.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	waitStalled(t, &written)
+
+	conns := srv.Connections()
+	if len(conns) != 1 {
+		t.Fatalf("want 1 connection, got %d", len(conns))
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Disconnect(conns[0].SessionID, "bye") }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("disconnect: %v", err)
+		}
+	case <-time.After(messageWriteTimeout + time.Second*2):
+		t.Fatal("Disconnect blocked by a stalled client")
+	}
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- client.Wait() }()
+	select {
+	case <-waitErr:
+	case <-time.After(time.Second * 2):
+		t.Fatal("connection not closed after Disconnect")
+	}
+}
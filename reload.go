@@ -0,0 +1,70 @@
+package sshd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileReloader 记录文件的修改时间与大小, 文件变化后调用 load 重新加载; 加载失败时沿用旧的内容.
+type fileReloader struct {
+	filename string
+	// kind 日志中的文件类型, 如 "password file"
+	kind string
+	load func(data []byte) error
+
+	mut     sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+func newFileReloader(kind, filename string, load func(data []byte) error) *fileReloader {
+	return &fileReloader{filename: filepath.Clean(filename), kind: kind, load: load}
+}
+
+// reload 读取文件并调用 load
+func (fr *fileReloader) reload() error {
+	fi, err := os.Stat(fr.filename)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(fr.filename)
+	if err != nil {
+		return err
+	}
+	if err := fr.load(data); err != nil {
+		return fmt.Errorf("sshd: parse %s: %w", fr.filename, err)
+	}
+
+	fr.mut.Lock()
+	fr.modTime = fi.ModTime()
+	fr.size = fi.Size()
+	fr.mut.Unlock()
+	return nil
+}
+
+// reloadIfChanged 文件变化后重新加载, 错误输出到 logger, logger 为 nil 时为 log.Default
+func (fr *fileReloader) reloadIfChanged(logger *log.Logger) {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	fi, err := os.Stat(fr.filename)
+	if err != nil {
+		logger.Printf("sshd: stat %s %s: %v", fr.kind, fr.filename, err)
+		return
+	}
+
+	fr.mut.Lock()
+	changed := !fi.ModTime().Equal(fr.modTime) || fi.Size() != fr.size
+	fr.mut.Unlock()
+	if !changed {
+		return
+	}
+	if err := fr.reload(); err != nil {
+		logger.Printf("sshd: reload %s %s: %v", fr.kind, fr.filename, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -19,6 +20,9 @@ type (
 	// ConnContext 为 TCP 连接创建 context, 如在 context 中注入 uuid 等.
 	ConnContext func(conn net.Conn) context.Context
 
+	// BaseContext 为 listener 创建基础 context, 与 http.Server.BaseContext 类似.
+	BaseContext func(ln net.Listener) context.Context
+
 	// GetSshServerConfig 获取 *ssh.ServerConfig 实例的函数, 将在 ssh 握手前调用.
 	GetSshServerConfig func(ctx context.Context) *ssh.ServerConfig
 )
@@ -26,11 +30,24 @@ type (
 // Server 支持为每一个 TCP 连接创建独立的 context, 确保在多次认证情况下 context 唯一.
 // 支持 PROXY protocol, 并能够在处理 Channel 的请求时获取到 PROXY protocol 源数据.
 type Server struct {
-	ctx      context.Context
-	cancel   context.CancelFunc
-	once     sync.Once // once cancel
-	connWG   sync.WaitGroup
-	listener net.Listener
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once // once cancel
+
+	// defaultsOnce 多个 listener 同时 Serve 时, 仅设置一次默认值
+	defaultsOnce sync.Once
+
+	inShutdown atomic.Bool
+	mut        sync.Mutex
+	listeners  map[net.Listener]struct{}
+	handshakes map[net.Conn]struct{}
+	onShutdown []func()
+
+	accessList atomic.Pointer[AccessList]
+	limiter    connLimiter
+
+	connsMut sync.Mutex
+	conns    map[*serverConn]struct{}
 
 	// ConnCallback 在接收 TCP 后, 对连接处理, 如 PROXY protocol 等.
 	ConnCallback ConnCallback
@@ -39,10 +56,30 @@ type Server struct {
 	// 将在建立 TCP 连接之后调用.
 	ConnContext ConnContext
 
+	// BaseContext 为 listener 创建基础 context, 其中的值可从每个连接的 context 中获取,
+	// 查找顺序为 ConnContext, BaseContext, ServeContext 的 context.
+	BaseContext BaseContext
+
 	// GetSshServerConfig 获取 *ssh.ServerConfig 实例的函数, 将在 ssh 握手前调用.
 	// 入参的 context 来源于 ConnContext.
 	GetSshServerConfig GetSshServerConfig
 
+	// Authenticator 每个连接的认证器, 将绑定连接的 context 后设置到 ssh.ServerConfig 的副本上,
+	// 从而多个 Server 可独立配置认证. 为 nil 时使用 DefaultAuthenticator.
+	Authenticator Authenticator
+
+	// PublicKeyCallback 等为可获取连接 context 的认证回调, 先于 Authenticator 尝试.
+	// 无需再为每个连接复制 ssh.ServerConfig.
+	PublicKeyCallback           PublicKeyCallback
+	PasswordCallback            PasswordCallback
+	KeyboardInteractiveCallback KeyboardInteractiveCallback
+
+	// AuthEventCallback 每次认证尝试后调用, 可用于审计登录.
+	AuthEventCallback AuthEventCallback
+
+	// ConnState 连接的状态变化时调用, 可用于统计等.
+	ConnState ConnStateHook
+
 	// Handler 建立 ssh channel 时调用 Handler.ServeChannel.
 	// 默认为 DefaultServeMux, 不会处理任何类型的 channel.
 	Handler Handler
@@ -54,6 +91,52 @@ type Server struct {
 	// IdleTimeout 连接空闲时间, 默认为 30m, 在关闭 tcp 连接时设置读写超时
 	IdleTimeout time.Duration
 
+	// ChannelIdleTimeout channel 无数据读写的时长上限, 超时后断开连接, 为 0 时不限制.
+	// 与 ReadTimeout 不同, 连接层的 keepalive 等请求不计入活动.
+	ChannelIdleTimeout time.Duration
+	// IdleOnlyWithoutChannels 为 true 时, 仅在没有打开的 channel 时计算空闲时长.
+	IdleOnlyWithoutChannels bool
+	// IdleWarning 在空闲断开前的该时长, 向交互式 (分配了 pty) 的 session 写入 IdleWarningMessage.
+	IdleWarning time.Duration
+	// IdleWarningMessage 为空时提示剩余的时间
+	IdleWarningMessage string
+
+	// MaxConnectionDuration 连接的最长时间, MaxSessionDuration 每个 session 的最长时间, 为 0 时不限制.
+	// 可由认证回调通过 Permissions.Extensions 的 PermExtMaxConnectionDuration 等为每个用户覆盖.
+	// 到期后向 session 写入 MaxDurationMessage 与 exit-status 并关闭, 剩余时间见 ChannelChain.RemainingTime.
+	MaxConnectionDuration time.Duration
+	MaxSessionDuration    time.Duration
+	// MaxDurationMessage 为空时为 DefaultMaxDurationMessage
+	MaxDurationMessage string
+
+	// KeyBlocklist 公钥黑名单, 在任何公钥认证回调前检查.
+	KeyBlocklist *KeyBlocklist
+
+	// AccountLockout 账户在多次认证失败后被锁定, 与客户端 IP 无关.
+	AccountLockout *AccountLockout
+
+	// AccessSchedule 按用户或组限制登录的时间段, 时间段结束时断开已建立的连接.
+	AccessSchedule *AccessSchedule
+
+	// LoginGraceTime 握手与认证的总时长上限, 超时后断开 TCP 连接, 为 0 时不限制.
+	LoginGraceTime time.Duration
+	// MaxAuthTries 每个连接的最大认证次数, 为 0 时使用 golang.org/x/crypto/ssh 的默认值 6,
+	// 为负数时不限制.
+	MaxAuthTries int
+
+	// MaxConnections 最大并发连接数, 超过后新的 TCP 连接将被直接关闭, 为 0 时不限制.
+	MaxConnections int
+	// MaxConnectionsPerIP 每个客户端 IP 的最大并发连接数, 在 ssh 握手前检查, 为 0 时不限制.
+	// IP 取自 ConnCallback 处理后的连接, 因此支持 PROXY protocol.
+	MaxConnectionsPerIP int
+	// MaxSessionsPerUser 每个用户的最大并发连接数, 在认证通过后检查, 为 0 时不限制.
+	MaxSessionsPerUser int
+	// MaxStartups 未完成认证的连接的随机早期丢弃, 为 nil 时不限制.
+	MaxStartups *MaxStartups
+
+	// ShutdownMessage Shutdown 时写入每个 session 的 stderr, 为空时不写入.
+	ShutdownMessage string
+
 	// ErrLogger 输出捕获到的错误日志, 默认为 log.Default
 	ErrLogger *log.Logger
 }
@@ -79,43 +162,112 @@ var (
 
 // ListenAndServe 监听 TCP 连接, 如果 addr 为空字符串则监听地址为 ":2222"
 func (srv *Server) ListenAndServe(addr string) error {
+	return srv.ListenAndServeContext(context.Background(), addr)
+}
+
+// ListenAndServeContext 同 ListenAndServe, ctx 的用法见 ServeContext.
+func (srv *Server) ListenAndServeContext(ctx context.Context, addr string) error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
 	if len(addr) == 0 {
 		addr = ":2222"
 	}
 
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
-		srv.onceCancel()
 		return err
 	}
 
-	return srv.Serve(ln)
+	return srv.ServeContext(ctx, ln)
 }
 
+// Serve 接受 ln 上的连接, 在 Shutdown 或 Close 后返回 ErrServerClosed.
 func (srv *Server) Serve(ln net.Listener) error {
+	return srv.serve(context.Background(), ln)
+}
+
+// ServeContext 同 Serve, 每个连接的 context 可获取 ctx 中的值 (如 logger, tracer).
+// ctx 被取消时将优雅关闭 Server, 并在 Shutdown 完成后返回 ErrServerClosed;
+// 若有连接迟迟不结束, 可调用 Close 强制关闭.
+func (srv *Server) ServeContext(ctx context.Context, ln net.Listener) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	wait := srv.shutdownWhenDone(ctx)
+	err := srv.serve(ctx, ln)
+	wait()
+	return err
+}
+
+// shutdownWhenDone 在 ctx 被取消时调用 Shutdown. 返回的 wait 停止监听 ctx, 若已开始 Shutdown 则等待其完成.
+func (srv *Server) shutdownWhenDone(ctx context.Context) (wait func()) {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	shutdown := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		select {
+		case <-ctx.Done():
+			if err := srv.Shutdown(context.Background()); err != nil {
+				srv.logf("sshd: shutdown: %v", err)
+			}
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		if ctx.Err() == nil {
+			close(stop)
+		}
+		<-shutdown
+	}
+}
+
+func (srv *Server) serve(ctx context.Context, ln net.Listener) error {
 	srv.setDefaults()
-	defer srv.onceCancel()
 
-	srv.listener = ln
+	// 与 http.Server.Serve 一致, Shutdown 之后调用时关闭 listener
+	if !srv.trackListener(ln, true) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer srv.trackListener(ln, false)
 
+	base := srv.baseContext(ctx, ln)
+	l := asListener(ln)
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			select {
-			case <-srv.ctx.Done():
+			if srv.shuttingDown() {
 				return ErrServerClosed
-			default:
-				srv.logf("sshd: accept serving %s: %v", ln.Addr(), err)
 			}
+			srv.logf("sshd: accept serving %s: %v", ln.Addr(), err)
 			continue
 		}
+		if srv.shuttingDown() {
+			conn.Close()
+			return ErrServerClosed
+		}
 
-		srv.connWG.Add(1)
-		go srv.handshake(conn)
+		if !srv.acquireConn() {
+			srv.logf("sshd: too many connections, max %d, drop connection from %s", srv.MaxConnections, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		srv.trackHandshake(conn, true)
+		srv.setConnState(conn, StateNew, nil)
+		go srv.handshake(base, l, conn)
 	}
 }
 
-func (srv *Server) handshake(conn net.Conn) {
+func (srv *Server) handshake(base context.Context, l *Listener, conn net.Conn) {
+	rawConn := conn
+	var sc *serverConn
 	defer func() {
 		if r := recover(); r != nil {
 			buf := make([]byte, maxStackInfoSize)
@@ -123,37 +275,131 @@ func (srv *Server) handshake(conn net.Conn) {
 			srv.logf("sshd: panic serving %s: %v\n%s", conn.RemoteAddr(), r, buf)
 			conn.Close()
 		}
-		srv.connWG.Done()
+		if sc != nil {
+			sc.setState(StateClosed)
+		} else {
+			srv.setConnState(rawConn, StateClosed, nil)
+		}
+		srv.trackHandshake(rawConn, false)
+		srv.releaseConn()
 	}()
 
-	if srv.ConnCallback != nil {
-		conn = srv.ConnCallback(conn)
+	conn = wrapUnixConn(conn)
+	if connCallback := l.connCallback(srv); connCallback != nil {
+		conn = connCallback(conn)
 	}
-	// spawn context for this connection
-	var ctx = context.Background()
-	if srv.ConnContext != nil {
-		ctx = srv.ConnContext(conn)
+
+	authDone, release, err := srv.acquireStartup(addrIP(conn.RemoteAddr()))
+	if err != nil {
+		srv.logf("sshd: %v", err)
+		conn.Close()
+		return
 	}
+	defer release()
+
+	// spawn context for this connection, cancelled when the connection closes or the server shuts down
+	ctx, cancel := srv.newConnContext(base, conn)
+	defer cancel()
 	newConn := &Conn{
 		Conn:         conn,
-		readTimeout:  srv.ReadTimeout,
-		writeTimeout: srv.WriteTimeout,
-		idleTimeout:  srv.IdleTimeout,
+		readTimeout:  l.readTimeout(srv),
+		writeTimeout: l.writeTimeout(srv),
+		idleTimeout:  l.idleTimeout(srv),
 	}
 
 	// ssh handshake
-	ssConf := srv.GetSshServerConfig(ctx)
+	ssConf := l.getSshServerConfig(srv)(ctx)
+	if auth := srv.authenticator(); auth != nil {
+		ssConf = bindAuthenticator(ctx, ssConf, auth, false)
+	} else {
+		ssConf = bindAuthenticator(ctx, ssConf, DefaultAuthenticator, true)
+	}
+	connAuth := srv.newConnAuth(ctx)
+	ssConf = connAuth.wrap(ssConf)
+	if srv.MaxAuthTries != 0 {
+		ssConf.MaxAuthTries = srv.MaxAuthTries
+	}
+
+	// 握手与认证须在 LoginGraceTime 内完成, 否则断开 TCP 连接
+	var graceExceeded int32
+	var graceTimer *time.Timer
+	loginGraceTime := l.loginGraceTime(srv)
+	if loginGraceTime > 0 {
+		graceTimer = time.AfterFunc(loginGraceTime, func() {
+			atomic.StoreInt32(&graceExceeded, 1)
+			conn.Close()
+		})
+	}
+
+	srv.setConnState(rawConn, StateHandshaking, nil)
 	sshConn, newChannels, reqs, err := ssh.NewServerConn(newConn, ssConf)
+	authDone()
+	// 认证结束后立即停止计时, 否则已认证的连接会在 LoginGraceTime 到期时被断开
+	if graceTimer != nil && !graceTimer.Stop() {
+		atomic.StoreInt32(&graceExceeded, 1)
+	}
 	if err != nil {
-		srv.logf("sshd: handshake with %s error:%v", conn.RemoteAddr(), err)
+		maxTries := maxAuthTries(ssConf)
+		switch {
+		case srv.shuttingDown():
+			srv.logf("sshd: server is shutting down, close handshake with %s", conn.RemoteAddr())
+		case atomic.LoadInt32(&graceExceeded) == 1:
+			srv.logf("sshd: login grace time %s exceeded for %s", loginGraceTime, conn.RemoteAddr())
+		case maxTries > 0 && connAuth.authFailures() >= maxTries:
+			srv.logf("sshd: too many authentication failures for %s from %s, max %d",
+				connAuth.user(), conn.RemoteAddr(), maxTries)
+		default:
+			srv.logf("sshd: handshake with %s error:%v", conn.RemoteAddr(), err)
+		}
 		return
 	}
+	if atomic.LoadInt32(&graceExceeded) == 1 {
+		srv.logf("sshd: login grace time %s exceeded for %s", loginGraceTime, conn.RemoteAddr())
+		return
+	}
+	connAuth.authenticated(sshConn)
 	defer newConn.Close()
 
+	if d := srv.maxDuration(sshConn.Permissions, PermExtMaxConnectionDuration, srv.MaxConnectionDuration); d > 0 {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithTimeout(ctx, d)
+		defer cancelDeadline()
+	}
+	sc = srv.newServerConn(ctx, l, rawConn, newConn, sshConn)
+	sc.setState(StateAuthenticated)
+
+	releaseUser, err := srv.acquireUser(sshConn.User())
+	if err != nil {
+		srv.logf("sshd: %v, disconnecting %s", err, conn.RemoteAddr())
+		return
+	}
+	defer releaseUser()
+
+	srv.trackConn(sc)
+	defer srv.untrackConn(sc)
+	srv.trackHandshake(rawConn, false)
+	if srv.shuttingDown() {
+		return
+	}
+	if srv.AccessSchedule != nil {
+		sc.enforceAccessSchedule(srv.AccessSchedule)
+	}
+	if srv.ChannelIdleTimeout > 0 {
+		go sc.watchIdle()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		timer := time.AfterFunc(time.Until(deadline), sc.expire)
+		defer timer.Stop()
+	}
+
 	// handle channels and requests
 	go ssh.DiscardRequests(reqs)
 	for newChannel := range newChannels {
-		go srv.handleNewChannel(ctx, ssConf, sshConn, newChannel)
+		if srv.shuttingDown() {
+			newChannel.Reject(ssh.Prohibited, "server is shutting down")
+			continue
+		}
+		go srv.handleNewChannel(ctx, ssConf, sc, newChannel)
 	}
 
 	// close tcp connection
@@ -162,7 +408,19 @@ func (srv *Server) handshake(conn net.Conn) {
 	}
 }
 
-func (srv *Server) handleNewChannel(ctx context.Context, conf *ssh.ServerConfig, conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+// maxAuthTries 与 golang.org/x/crypto/ssh 一致的认证次数上限, 为 0 时为 6, 为负数时不限制 (返回 0).
+func maxAuthTries(conf *ssh.ServerConfig) int {
+	switch {
+	case conf.MaxAuthTries == 0:
+		return 6
+	case conf.MaxAuthTries < 0:
+		return 0
+	}
+	return conf.MaxAuthTries
+}
+
+func (srv *Server) handleNewChannel(ctx context.Context, conf *ssh.ServerConfig, sc *serverConn, newChannel ssh.NewChannel) {
+	conn := sc.conn
 	defer func() {
 		if r := recover(); r != nil {
 			buf := make([]byte, maxStackInfoSize)
@@ -172,14 +430,60 @@ func (srv *Server) handleNewChannel(ctx context.Context, conf *ssh.ServerConfig,
 		}
 	}()
 
+	// the channel's context is cancelled when the channel closes, or when the handler returns without accepting it
+	var cancel context.CancelFunc
+	d := srv.maxDuration(conn.Permissions, PermExtMaxSessionDuration, srv.MaxSessionDuration)
+	if d > 0 && newChannel.ChannelType() == "session" {
+		ctx, cancel = context.WithTimeout(ctx, d)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	nc := sc.trackNewChannel(newChannel, cancel)
+	if d > 0 && newChannel.ChannelType() == "session" {
+		nc.deadline, _ = ctx.Deadline()
+	}
+	defer func() {
+		if !nc.accepted.Load() {
+			cancel()
+		}
+	}()
+
 	chain := NewChannelChain(srv.Handler, conf)
-	err := chain.entry(ctx, conn, newChannel)
+	chain.listener = sc.listener
+	err := chain.entry(ctx, conn, nc)
 	if err != nil {
 		srv.logf("sshd: serving %s the channel error: %v\n", conn.RemoteAddr(), err)
 		return
 	}
 }
 
+// SetAccessList 设置访问控制列表, 可在 Server 运行时调用, 对之后的认证生效.
+// 为 nil 时不做限制.
+func (srv *Server) SetAccessList(al *AccessList) {
+	srv.accessList.Store(al)
+}
+
+// AccessList 获取当前的访问控制列表
+func (srv *Server) AccessList() *AccessList {
+	return srv.accessList.Load()
+}
+
+// authenticator 组合 Server 的认证回调与 Authenticator, 均未设置时返回 nil.
+func (srv *Server) authenticator() Authenticator {
+	funcs := &AuthFuncs{
+		PublicKeyCallback:           srv.PublicKeyCallback,
+		PasswordCallback:            srv.PasswordCallback,
+		KeyboardInteractiveCallback: srv.KeyboardInteractiveCallback,
+	}
+	if funcs.PublicKeyCallback == nil && funcs.PasswordCallback == nil && funcs.KeyboardInteractiveCallback == nil {
+		return srv.Authenticator
+	}
+	if srv.Authenticator == nil {
+		return funcs
+	}
+	return NewAuthChain(funcs, srv.Authenticator)
+}
+
 func (srv *Server) logf(format string, args ...interface{}) {
 	if srv.ErrLogger != nil {
 		srv.ErrLogger.Printf(format, args...)
@@ -189,6 +493,10 @@ func (srv *Server) logf(format string, args ...interface{}) {
 }
 
 func (srv *Server) setDefaults() {
+	srv.defaultsOnce.Do(srv.initDefaults)
+}
+
+func (srv *Server) initDefaults() {
 	if srv.ctx == nil {
 		srv.ctx, srv.cancel = context.WithCancel(context.Background())
 	} else if srv.ctx.Done() == nil || srv.cancel == nil {
@@ -210,33 +518,3 @@ func (srv *Server) onceCancel() {
 		}
 	})
 }
-
-// Shutdown 入参的 context, 如果非空则可用于优雅关闭
-func (srv *Server) Shutdown(ctx context.Context) error {
-	defer srv.onceCancel()
-
-	if ctx == nil || ctx.Done() == nil {
-		return srv.listener.Close()
-	}
-	<-ctx.Done()
-
-	// graceful shutdown
-	var done = make(chan error, 1)
-	go func() {
-		srv.connWG.Wait()
-
-		if srv.listener == nil {
-			done <- nil
-			return
-		}
-		done <- srv.listener.Close()
-	}()
-
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-
-	case err := <-done:
-		return err
-	}
-}
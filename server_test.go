@@ -0,0 +1,182 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestServer 在 127.0.0.1 上启动 Server, 默认不需要认证, 返回监听地址.
+// session 为 nil 时, session channel 保持打开直到连接关闭.
+func newTestServer(t *testing.T, session HandlerFunc, options ...Option) (*Server, string) {
+	t.Helper()
+
+	if session == nil {
+		session = func(cc *ChannelChain, conn *ssh.ServerConn, nc ssh.NewChannel) error {
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				return err
+			}
+			go ssh.DiscardRequests(reqs)
+			<-cc.Context.Done()
+			return ch.Close()
+		}
+	}
+	mux := NewServeMux()
+	mux.Handle("session", session)
+
+	hostKey, err := GenerateEd25519HostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &ssh.ServerConfig{NoClientAuth: true}
+	conf.AddHostKey(hostKey)
+	// 在前面, 可被 options 中的 WithGetSshServerConfig 覆盖
+	options = append([]Option{WithGetSshServerConfig(func(ctx context.Context) *ssh.ServerConfig { return conf })}, options...)
+	srv := NewServer(mux, options...)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv, ln.Addr().String()
+}
+
+func dialTestServer(t *testing.T, addr string) *ssh.Client {
+	t.Helper()
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second * 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestLoginGraceTimeAfterAuthentication(t *testing.T) {
+	const grace = time.Millisecond * 200
+	_, addr := newTestServer(t, nil, WithLoginGraceTime(grace))
+	client := dialTestServer(t, addr)
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	time.Sleep(grace * 3)
+
+	if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+		t.Fatalf("connection closed after login grace time: %v", err)
+	}
+	if _, err := client.NewSession(); err != nil {
+		t.Fatalf("open session after login grace time: %v", err)
+	}
+}
+
+func TestLoginGraceTimeExceeded(t *testing.T) {
+	const grace = time.Millisecond * 200
+	_, addr := newTestServer(t, nil, WithLoginGraceTime(grace))
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 不发送版本号, 等待服务端断开
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 256)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				t.Fatal("connection not closed after login grace time")
+			}
+			return
+		}
+	}
+}
+
+func TestServeAfterShutdownClosesListener(t *testing.T) {
+	srv := NewServer(NewServeMux())
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Serve(ln); err != ErrServerClosed {
+		t.Fatalf("want ErrServerClosed, got %v", err)
+	}
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("want listener closed, got %v", err)
+	}
+}
+
+// logLines 将每行日志发送到 channel
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	select {
+	case l <- string(p):
+	default:
+	}
+	return len(p), nil
+}
+
+func TestTooManyAuthFailuresLoggedWithDefaultLimit(t *testing.T) {
+	hostKey, err := GenerateEd25519HostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, errors.New("wrong password")
+		},
+	}
+	conf.AddHostKey(hostKey)
+
+	lines := make(logLines, 64)
+	_, addr := newTestServer(t, nil,
+		WithGetSshServerConfig(func(ctx context.Context) *ssh.ServerConfig { return conf }),
+		WithErrLogger(log.New(lines, "", 0)))
+
+	// MaxAuthTries 为 0, 使用默认值 6
+	_, err = ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.RetryableAuthMethod(ssh.Password("wrong"), 10)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second * 5,
+	})
+	if err == nil {
+		t.Fatal("want authentication error")
+	}
+
+	timeout := time.After(time.Second * 2)
+	for {
+		select {
+		case line := <-lines:
+			if strings.Contains(line, "too many authentication failures") {
+				if !strings.Contains(line, "max 6") {
+					t.Errorf("want effective limit 6 in %q", line)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("too many authentication failures not logged")
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package sshd
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// messageWriteTimeout 写入断开原因等消息的最长等待时间
+	messageWriteTimeout = time.Second
+	// channelRequestBuffer 转发 channel request 的缓冲, handler 未及时读取时超出的 request 被拒绝
+	channelRequestBuffer = 64
+)
+
+// serverConn 认证通过后的单个 ssh 连接, 跟踪已接受的 channel.
+type serverConn struct {
+	srv         *Server
+	ctx         context.Context
+	conn        *ssh.ServerConn
+	listener    *Listener
+	rawConn     net.Conn
+	netConn     *Conn
+	sessionID   string
+	connectedAt time.Time
+
+	// stateMut 串行化状态的变化, 先于 mut 加锁
+	stateMut sync.Mutex
+	state    ConnState
+
+	mut      sync.Mutex
+	channels map[*trackedChannel]struct{}
+	// pending 尚未被 Accept 或 Reject 的 channel 数
+	pending int
+	closed  bool
+
+	// lastActivity channel 最近一次读写或打开, 关闭的时间 (UnixNano)
+	lastActivity atomic.Int64
+}
+
+func (srv *Server) newServerConn(ctx context.Context, l *Listener, rawConn net.Conn, netConn *Conn, conn *ssh.ServerConn) *serverConn {
+	sc := &serverConn{
+		srv:         srv,
+		ctx:         ctx,
+		conn:        conn,
+		listener:    l,
+		rawConn:     rawConn,
+		netConn:     netConn,
+		sessionID:   hex.EncodeToString(conn.SessionID()),
+		connectedAt: time.Now(),
+		channels:    make(map[*trackedChannel]struct{}),
+	}
+	sc.touch()
+	return sc
+}
+
+// touch 记录 channel 的活动
+func (sc *serverConn) touch() {
+	sc.lastActivity.Store(time.Now().UnixNano())
+}
+
+// trackNewChannel 包装 ssh.NewChannel, 使 Accept 后的 channel 被跟踪, 并在 channel 关闭后调用 cancel.
+func (sc *serverConn) trackNewChannel(newChannel ssh.NewChannel, cancel context.CancelFunc) *trackedNewChannel {
+	sc.mut.Lock()
+	sc.pending++
+	sc.mut.Unlock()
+	return &trackedNewChannel{NewChannel: newChannel, sc: sc, cancel: cancel}
+}
+
+func (sc *serverConn) donePending() {
+	sc.mut.Lock()
+	sc.pending--
+	sc.mut.Unlock()
+}
+
+func (sc *serverConn) addChannel(ch *trackedChannel) {
+	sc.stateMut.Lock()
+	defer sc.stateMut.Unlock()
+
+	sc.mut.Lock()
+	sc.channels[ch] = struct{}{}
+	n := len(sc.channels)
+	sc.mut.Unlock()
+	sc.touch()
+	if n == 1 {
+		sc.setStateLocked(StateActive)
+	}
+}
+
+func (sc *serverConn) removeChannel(ch *trackedChannel) {
+	sc.stateMut.Lock()
+	defer sc.stateMut.Unlock()
+
+	sc.mut.Lock()
+	delete(sc.channels, ch)
+	n := len(sc.channels)
+	sc.mut.Unlock()
+	sc.touch()
+	if n == 0 {
+		sc.setStateLocked(StateIdle)
+	}
+}
+
+// sessions 已接受的 session channel
+func (sc *serverConn) sessions() []*trackedChannel {
+	sc.mut.Lock()
+	defer sc.mut.Unlock()
+
+	sessions := make([]*trackedChannel, 0, len(sc.channels))
+	for ch := range sc.channels {
+		if ch.channelType == "session" {
+			sessions = append(sessions, ch)
+		}
+	}
+	return sessions
+}
+
+// info 连接信息的快照
+func (sc *serverConn) info() ConnInfo {
+	info := ConnInfo{
+		Listener:      sc.listener.String(),
+		User:          sc.conn.User(),
+		ClientIP:      addrIP(sc.conn.RemoteAddr()),
+		RemoteAddr:    sc.conn.RemoteAddr(),
+		SessionID:     sc.sessionID,
+		ClientVersion: string(sc.conn.ClientVersion()),
+		ConnectedAt:   sc.connectedAt,
+		BytesRead:     sc.netConn.BytesRead(),
+		BytesWritten:  sc.netConn.BytesWritten(),
+	}
+
+	sc.mut.Lock()
+	for ch := range sc.channels {
+		info.Channels = append(info.Channels, ch.channelType)
+	}
+	sc.mut.Unlock()
+	sort.Strings(info.Channels)
+	return info
+}
+
+// idle 是否没有打开的 channel
+func (sc *serverConn) idle() bool {
+	sc.mut.Lock()
+	defer sc.mut.Unlock()
+	return len(sc.channels) == 0 && sc.pending == 0
+}
+
+// writeMessage 向每个 session 的 stderr 写入 msg, 不计入 channel 的活动, 见 writeMessages.
+func (sc *serverConn) writeMessage(msg string) {
+	writeMessages(sc.sessions(), msg)
+}
+
+// closeWithMessage 向每个 session 的 stderr 写入 msg 后关闭连接, 写入未完成时同样关闭连接.
+func (sc *serverConn) closeWithMessage(msg string) error {
+	sc.mut.Lock()
+	if sc.closed {
+		sc.mut.Unlock()
+		return nil
+	}
+	sc.closed = true
+	sc.mut.Unlock()
+
+	if len(msg) > 0 {
+		sc.writeMessage(msg)
+	}
+	return sc.conn.Close()
+}
+
+// writeMessages 并发地向 channels 的 stderr 写入 msg, 最多等待 messageWriteTimeout.
+// 客户端不读取输出时窗口耗尽, 写入会一直阻塞, 直到 channel 关闭.
+func writeMessages(channels []*trackedChannel, msg string) {
+	if len(channels) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, ch := range channels {
+		wg.Add(1)
+		go func(ch *trackedChannel) {
+			defer wg.Done()
+			ch.writeMessage(msg)
+		}(ch)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	timer := time.NewTimer(messageWriteTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+}
+
+type trackedNewChannel struct {
+	ssh.NewChannel
+	sc       *serverConn
+	once     sync.Once
+	cancel   context.CancelFunc
+	accepted atomic.Bool
+	// deadline 非零时, session 在此时结束
+	deadline time.Time
+}
+
+// Accept 接受 channel, 在 channel 关闭 (requests 被关闭) 后取消跟踪.
+func (nc *trackedNewChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	ch, reqs, err := nc.NewChannel.Accept()
+	if err != nil {
+		nc.once.Do(nc.sc.donePending)
+		return ch, reqs, err
+	}
+
+	tc := &trackedChannel{Channel: ch, channelType: nc.ChannelType(), sc: nc.sc}
+	nc.sc.addChannel(tc)
+	nc.accepted.Store(true)
+	nc.once.Do(nc.sc.donePending)
+
+	var timer *time.Timer
+	if !nc.deadline.IsZero() {
+		timer = time.AfterFunc(time.Until(nc.deadline), func() {
+			nc.sc.srv.logf("sshd: session of %s from %s exceeded maximum duration", nc.sc.conn.User(), nc.sc.conn.RemoteAddr())
+			tc.expire(nc.sc.srv.maxDurationMessage())
+		})
+	}
+
+	// 转发时不阻塞, 使 handler 不读取 requests 时仍能在 channel 关闭后取消跟踪
+	out := make(chan *ssh.Request, channelRequestBuffer)
+	go func() {
+		if timer != nil {
+			defer timer.Stop()
+		}
+		defer nc.cancel()
+		defer nc.sc.removeChannel(tc)
+		defer close(out)
+		for req := range reqs {
+			if req.Type == "pty-req" {
+				tc.pty.Store(true)
+			}
+			select {
+			case out <- req:
+			default:
+				_ = req.Reply(false, nil)
+			}
+		}
+	}()
+	return tc, out, nil
+}
+
+func (nc *trackedNewChannel) Reject(reason ssh.RejectionReason, message string) error {
+	defer nc.once.Do(nc.sc.donePending)
+	return nc.NewChannel.Reject(reason, message)
+}
+
+// trackedChannel 记录 channel 的读写活动
+type trackedChannel struct {
+	ssh.Channel
+	channelType string
+	sc          *serverConn
+	// pty 是否为交互式的 session
+	pty atomic.Bool
+}
+
+func (ch *trackedChannel) Read(data []byte) (int, error) {
+	n, err := ch.Channel.Read(data)
+	if n > 0 {
+		ch.sc.touch()
+	}
+	return n, err
+}
+
+func (ch *trackedChannel) Write(data []byte) (int, error) {
+	n, err := ch.Channel.Write(data)
+	if n > 0 {
+		ch.sc.touch()
+	}
+	return n, err
+}
+
+func (ch *trackedChannel) Stderr() io.ReadWriter {
+	return &activityReadWriter{ReadWriter: ch.Channel.Stderr(), sc: ch.sc}
+}
+
+// writeMessage 向 stderr 写入 msg, 不计入 channel 的活动. 窗口耗尽时阻塞, 应通过 writeMessages 调用.
+func (ch *trackedChannel) writeMessage(msg string) {
+	_, _ = ch.Channel.Stderr().Write([]byte(msg + "\r\n"))
+}
+
+type activityReadWriter struct {
+	io.ReadWriter
+	sc *serverConn
+}
+
+func (rw *activityReadWriter) Read(data []byte) (int, error) {
+	n, err := rw.ReadWriter.Read(data)
+	if n > 0 {
+		rw.sc.touch()
+	}
+	return n, err
+}
+
+func (rw *activityReadWriter) Write(data []byte) (int, error) {
+	n, err := rw.ReadWriter.Write(data)
+	if n > 0 {
+		rw.sc.touch()
+	}
+	return n, err
+}
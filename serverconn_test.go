@@ -0,0 +1,64 @@
+package sshd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestChannelUntrackedWithoutReadingRequests(t *testing.T) {
+	var mut sync.Mutex
+	var states []ConnState
+	hook := func(conn net.Conn, state ConnState, meta ssh.ConnMetadata) {
+		mut.Lock()
+		states = append(states, state)
+		mut.Unlock()
+	}
+
+	// handler 不读取 requests, 收到 request 后关闭 channel
+	srv, addr := newTestServer(t, func(cc *ChannelChain, conn *ssh.ServerConn, nc ssh.NewChannel) error {
+		ch, _, err := nc.Accept()
+		if err != nil {
+			return err
+		}
+		time.Sleep(time.Millisecond * 100)
+		return ch.Close()
+	}, WithConnState(hook))
+	client := dialTestServer(t, addr)
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = session.Setenv("LANG", "C")
+	_ = session.Wait()
+
+	deadline := time.Now().Add(time.Second * 2)
+	for {
+		conns := srv.Connections()
+		if len(conns) == 1 && len(conns[0].Channels) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("channel still tracked after close: %+v", conns)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	mut.Lock()
+	last := states[len(states)-1]
+	mut.Unlock()
+	if last != StateIdle {
+		t.Errorf("want state %s, got %s", StateIdle, last)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Errorf("shutdown: %v", err)
+	}
+}
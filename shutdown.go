@@ -0,0 +1,148 @@
+package sshd
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const shutdownPollIntervalMax = time.Millisecond * 500
+
+// RegisterOnShutdown 注册在 Shutdown 时调用的函数, 每个函数在独立的 goroutine 中执行.
+func (srv *Server) RegisterOnShutdown(f func()) {
+	srv.mut.Lock()
+	srv.onShutdown = append(srv.onShutdown, f)
+	srv.mut.Unlock()
+}
+
+// Shutdown 优雅关闭: 立即关闭 listener 与尚未完成认证的连接, 不再接受新的 channel,
+// 向每个 session 的 stderr 写入 ShutdownMessage, 并关闭没有 channel 的连接.
+// 之后等待全部 channel 结束, 或 ctx 结束, 此时返回 ctx.Err(), 剩余的连接可通过 Close 关闭.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	srv.inShutdown.Store(true)
+
+	srv.mut.Lock()
+	err := srv.closeListenersLocked()
+	for _, f := range srv.onShutdown {
+		go f()
+	}
+	srv.mut.Unlock()
+	srv.closeHandshakes()
+
+	// 不等待消息写入完成, 客户端不读取输出时写入会阻塞, 使 ctx 失效
+	if len(srv.ShutdownMessage) > 0 {
+		for _, sc := range srv.serverConns(nil) {
+			go sc.writeMessage(srv.ShutdownMessage)
+		}
+	}
+
+	pollInterval := time.Millisecond
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
+	for {
+		if srv.closeIdleConns() {
+			srv.onceCancel()
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+			if pollInterval *= 2; pollInterval > shutdownPollIntervalMax {
+				pollInterval = shutdownPollIntervalMax
+			}
+			timer.Reset(pollInterval)
+		}
+	}
+}
+
+// Close 立即关闭全部 listener 与连接, 不等待 channel 结束.
+func (srv *Server) Close() error {
+	srv.inShutdown.Store(true)
+
+	srv.mut.Lock()
+	err := srv.closeListenersLocked()
+	srv.mut.Unlock()
+	srv.closeHandshakes()
+
+	for _, sc := range srv.serverConns(nil) {
+		_ = sc.conn.Close()
+	}
+	srv.onceCancel()
+	return err
+}
+
+func (srv *Server) shuttingDown() bool {
+	return srv.inShutdown.Load()
+}
+
+// trackListener 记录 Serve 中的 listener, 以便 Shutdown 关闭
+func (srv *Server) trackListener(ln net.Listener, add bool) bool {
+	srv.mut.Lock()
+	defer srv.mut.Unlock()
+
+	if add {
+		if srv.shuttingDown() {
+			return false
+		}
+		if srv.listeners == nil {
+			srv.listeners = make(map[net.Listener]struct{})
+		}
+		srv.listeners[ln] = struct{}{}
+	} else {
+		delete(srv.listeners, ln)
+	}
+	return true
+}
+
+func (srv *Server) closeListenersLocked() error {
+	var err error
+	for ln := range srv.listeners {
+		if cerr := ln.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// trackHandshake 记录尚未完成认证的连接
+func (srv *Server) trackHandshake(conn net.Conn, add bool) {
+	srv.mut.Lock()
+	defer srv.mut.Unlock()
+
+	if add {
+		if srv.handshakes == nil {
+			srv.handshakes = make(map[net.Conn]struct{})
+		}
+		srv.handshakes[conn] = struct{}{}
+	} else {
+		delete(srv.handshakes, conn)
+	}
+}
+
+func (srv *Server) closeHandshakes() {
+	srv.mut.Lock()
+	defer srv.mut.Unlock()
+
+	for conn := range srv.handshakes {
+		_ = conn.Close()
+	}
+}
+
+// closeIdleConns 关闭没有 channel 的连接, 全部连接均已关闭时返回 true.
+func (srv *Server) closeIdleConns() bool {
+	srv.mut.Lock()
+	quiescent := len(srv.handshakes) == 0
+	srv.mut.Unlock()
+
+	for _, sc := range srv.serverConns(nil) {
+		quiescent = false
+		if sc.idle() {
+			_ = sc.conn.Close()
+		}
+	}
+	return quiescent
+}
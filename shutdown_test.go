@@ -0,0 +1,29 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShutdownStalledClientHonoursContext(t *testing.T) {
+	var written atomic.Int64
+	srv, addr := newTestServer(t, stalledSession(&written), WithShutdownMessage("server is going down"))
+	client := dialTestServer(t, addr)
+	if _, err := client.NewSession(); err != nil {
+		t.Fatal(err)
+	}
+	waitStalled(t, &written)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	start := time.Now()
+	if err := srv.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown returned after %s, deadline was 200ms", elapsed)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package sshd
+
+import "net"
+
+// listenUnixPrivate 监听后再修改所有者与权限
+func listenUnixPrivate(sock *UnixSocket) (net.Listener, error) {
+	ln, err := net.Listen("unix", sock.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := sock.chmod(sock.Path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// isConnRefused 无法可靠地判断 socket 文件是否无人监听, 不删除残留的文件
+func isConnRefused(err error) bool {
+	return false
+}
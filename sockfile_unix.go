@@ -0,0 +1,59 @@
+//go:build unix
+
+package sshd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// listenUnixPrivate 在 sock.Path 同目录下创建权限为 0700 的临时目录, 在其中监听并修改所有者与权限,
+// 再 rename 到 sock.Path. 不修改作用于整个进程的 umask.
+func listenUnixPrivate(sock *UnixSocket) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(sock.Path), ".sock")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	// 临时路径尽量短, 避免超出 sun_path 的长度限制
+	tmp := filepath.Join(dir, "s")
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// 关闭时删除的是 rename 后的文件
+	ln.SetUnlinkOnClose(false)
+
+	if err := sock.chmod(tmp); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, sock.Path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: ln, path: sock.Path}, nil
+}
+
+// unixListener 关闭时删除 socket 文件
+type unixListener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	l.once.Do(func() { _ = os.Remove(l.path) })
+	return err
+}
+
+// isConnRefused 连接 socket 文件被拒绝, 即文件存在但无人监听
+func isConnRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
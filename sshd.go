@@ -1,6 +1,7 @@
 package sshd
 
 import (
+	"context"
 	"net"
 
 	"golang.org/x/crypto/ssh"
@@ -15,17 +16,41 @@ func ListenAndServe(addr string, handler Handler, options ...Option) error {
 	return srv.ListenAndServe(addr)
 }
 
+// ListenAndServeContext ctx 被取消时优雅关闭 Server, 见 Server.ServeContext.
+func ListenAndServeContext(ctx context.Context, addr string, handler Handler, options ...Option) error {
+	return NewServer(handler, options...).ListenAndServeContext(ctx, addr)
+}
+
 // PublicKeyAuth 通过公钥认证, 如果认证不通过, error 应返回非 nil.
+// 设置到 DefaultAuthenticator, 对未设置 Authenticator 的 Server 生效.
 func PublicKeyAuth(fn func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)) {
-	DefaultSshServerConfig.PublicKeyCallback = fn
+	if fn == nil {
+		DefaultAuthenticator.PublicKeyCallback = nil
+		return
+	}
+	DefaultAuthenticator.PublicKeyCallback = func(_ context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		return fn(conn, key)
+	}
 }
 
 // PasswordAuth 通过密码认证
 func PasswordAuth(fn func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error)) {
-	DefaultSshServerConfig.PasswordCallback = fn
+	if fn == nil {
+		DefaultAuthenticator.PasswordCallback = nil
+		return
+	}
+	DefaultAuthenticator.PasswordCallback = func(_ context.Context, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		return fn(conn, password)
+	}
 }
 
 // KeyboardInteractiveAuth 通过交互方式认证
 func KeyboardInteractiveAuth(fn func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error)) {
-	DefaultSshServerConfig.KeyboardInteractiveCallback = fn
+	if fn == nil {
+		DefaultAuthenticator.KeyboardInteractiveCallback = nil
+		return
+	}
+	DefaultAuthenticator.KeyboardInteractiveCallback = func(_ context.Context, conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		return fn(conn, client)
+	}
 }
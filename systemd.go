@@ -0,0 +1,81 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart systemd 传递的第一个文件描述符, 即 SD_LISTEN_FDS_START
+const listenFdsStart = 3
+
+var ErrNoSystemdSockets = errors.New("sshd: no systemd sockets")
+
+// SystemdListeners 获取 systemd socket activation 传递的 listener (LISTEN_PID, LISTEN_FDS, LISTEN_FDNAMES),
+// Listener.Name 为 LISTEN_FDNAMES 中对应的名称, 即 socket unit 的 FileDescriptorName.
+// 返回的 Listener 可按名称设置 ConnCallback 等后传入 Server.ServeListeners.
+// 未以 socket activation 方式启动时返回 ErrNoSystemdSockets. unsetEnv 为 true 时清除上述环境变量,
+// 避免被子进程继承.
+func SystemdListeners(unsetEnv bool) ([]*Listener, error) {
+	if unsetEnv {
+		defer func() {
+			os.Unsetenv("LISTEN_PID")
+			os.Unsetenv("LISTEN_FDS")
+			os.Unsetenv("LISTEN_FDNAMES")
+		}()
+	}
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, ErrNoSystemdSockets
+	}
+	nfds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || nfds <= 0 {
+		return nil, ErrNoSystemdSockets
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); len(fdNames) > 0 {
+		names = strings.Split(fdNames, ":")
+	}
+
+	listeners := make([]*Listener, 0, nfds)
+	for i := 0; i < nfds; i++ {
+		fd := listenFdsStart + i
+		name := fmt.Sprintf("LISTEN_FD_%d", fd)
+		if i < len(names) && len(names[i]) > 0 {
+			name = names[i]
+		}
+
+		// net.FileListener 复制文件描述符, 之后关闭 systemd 传递的原描述符
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("sshd: systemd socket %s (fd %d): %w", name, fd, err)
+		}
+		listeners = append(listeners, &Listener{Listener: ln, Name: name})
+	}
+	return listeners, nil
+}
+
+// ListenAndServeSystemd 在 systemd socket activation 传递的全部 listener 上提供服务
+func (srv *Server) ListenAndServeSystemd() error {
+	listeners, err := SystemdListeners(true)
+	if err != nil {
+		return err
+	}
+
+	lns := make([]net.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		lns = append(lns, l)
+	}
+	return srv.ServeListeners(context.Background(), lns...)
+}
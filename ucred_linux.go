@@ -0,0 +1,29 @@
+//go:build linux
+
+package sshd
+
+import (
+	"net"
+	"syscall"
+)
+
+// unixPeerCred 通过 SO_PEERCRED 获取对端进程的凭证
+func unixPeerCred(conn *net.UnixConn) (pid int32, uid, gid uint32, err error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	if credErr != nil {
+		return 0, 0, 0, credErr
+	}
+	return cred.Pid, cred.Uid, cred.Gid, nil
+}
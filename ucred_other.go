@@ -0,0 +1,13 @@
+//go:build !linux
+
+package sshd
+
+import (
+	"errors"
+	"net"
+)
+
+// unixPeerCred 仅支持 Linux
+func unixPeerCred(conn *net.UnixConn) (pid int32, uid, gid uint32, err error) {
+	return 0, 0, 0, errors.New("sshd: peer credentials are not supported on this platform")
+}
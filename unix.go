@@ -0,0 +1,173 @@
+package sshd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+// UnixSocket Unix domain socket 的监听配置
+type UnixSocket struct {
+	Path string
+	// Mode socket 文件的权限, 为 0 时不修改: 设置了 Owner 或 Group 时为 0600, 否则由 umask 决定
+	Mode os.FileMode
+	// Owner, Group socket 文件的所有者与组, 可为名称或数字 ID, 为空时不修改
+	Owner string
+	Group string
+}
+
+// ListenUnix 监听 Unix domain socket. 若 Path 为无人监听的残留 socket 文件则先删除,
+// 若仍有进程在监听或 Path 不是 socket 文件则返回错误. listener 关闭时删除 socket 文件.
+// 设置了 Mode, Owner 或 Group 时, socket 文件先在仅所有者可访问的临时目录中创建,
+// 修改所有者与权限后再移动到 Path, 避免在此之前被其他用户连接.
+func ListenUnix(sock *UnixSocket) (net.Listener, error) {
+	if err := removeStaleSocket(sock.Path); err != nil {
+		return nil, err
+	}
+	if sock.Mode == 0 && len(sock.Owner) == 0 && len(sock.Group) == 0 {
+		return net.Listen("unix", sock.Path)
+	}
+	return listenUnixPrivate(sock)
+}
+
+// ListenAndServeUnix 监听 Unix domain socket 并提供服务
+func (srv *Server) ListenAndServeUnix(sock *UnixSocket) error {
+	if srv.shuttingDown() {
+		return ErrServerClosed
+	}
+	ln, err := ListenUnix(sock)
+	if err != nil {
+		return err
+	}
+	return srv.Serve(ln)
+}
+
+// chmod 修改 path 的所有者与权限, Mode 为 0 时为 0600.
+// 先修改所有者再修改权限, 避免权限对修改前的所有者生效.
+func (sock *UnixSocket) chmod(path string) error {
+	if err := sock.chown(path); err != nil {
+		return err
+	}
+	mode := sock.Mode
+	if mode == 0 {
+		mode = 0o600
+	}
+	return os.Chmod(path, mode)
+}
+
+func (sock *UnixSocket) chown(path string) error {
+	if len(sock.Owner) == 0 && len(sock.Group) == 0 {
+		return nil
+	}
+
+	uid, gid := -1, -1
+	if len(sock.Owner) > 0 {
+		u, err := user.Lookup(sock.Owner)
+		if err != nil {
+			if u, err = user.LookupId(sock.Owner); err != nil {
+				return err
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return err
+		}
+	}
+	if len(sock.Group) > 0 {
+		g, err := user.LookupGroup(sock.Group)
+		if err != nil {
+			if g, err = user.LookupGroupId(sock.Group); err != nil {
+				return err
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return err
+		}
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// removeStaleSocket 删除无人监听的 socket 文件, 仅在连接被拒绝 (ECONNREFUSED) 时认为无人监听,
+// 其他错误 (如无权限, 超时) 时保留文件并返回错误.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("sshd: %s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("sshd: socket %s is in use", path)
+	}
+	if !isConnRefused(err) {
+		return fmt.Errorf("sshd: check socket %s: %w", path, err)
+	}
+	return os.Remove(path)
+}
+
+// UnixPeerAddr Unix domain socket 对端的地址, 在 Linux 上包含对端进程的凭证 (SO_PEERCRED).
+type UnixPeerAddr struct {
+	Name    string
+	HasCred bool
+	PID     int32
+	UID     uint32
+	GID     uint32
+}
+
+func (a *UnixPeerAddr) Network() string {
+	return "unix"
+}
+
+// String 如 "unix:pid=123,uid=1000,gid=1000", 无凭证时为 "unix:" 加地址
+func (a *UnixPeerAddr) String() string {
+	if a.HasCred {
+		return fmt.Sprintf("unix:pid=%d,uid=%d,gid=%d", a.PID, a.UID, a.GID)
+	}
+	return "unix:" + a.Name
+}
+
+// clientIP 作为 ChannelChain.ClientIP 等的客户端 "IP", 如 "unix:uid=1000", 可用于 MaxConnectionsPerIP 等.
+func (a *UnixPeerAddr) clientIP() string {
+	if a.HasCred {
+		return "unix:uid=" + strconv.FormatUint(uint64(a.UID), 10)
+	}
+	return "unix"
+}
+
+// unixPeerConn 以 UnixPeerAddr 作为 RemoteAddr 的 Unix domain socket 连接
+type unixPeerConn struct {
+	net.Conn
+	addr *UnixPeerAddr
+}
+
+func (c *unixPeerConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// wrapUnixConn 为 Unix domain socket 连接获取对端的凭证, 其他连接原样返回.
+func wrapUnixConn(conn net.Conn) net.Conn {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return conn
+	}
+
+	addr := &UnixPeerAddr{}
+	if ra, ok := uc.RemoteAddr().(*net.UnixAddr); ok && ra != nil {
+		addr.Name = ra.Name
+	}
+	if pid, uid, gid, err := unixPeerCred(uc); err == nil {
+		addr.HasCred = true
+		addr.PID, addr.UID, addr.GID = pid, uid, gid
+	}
+	return &unixPeerConn{Conn: conn, addr: addr}
+}
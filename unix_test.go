@@ -0,0 +1,96 @@
+//go:build unix
+
+package sshd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshd.sock")
+
+	// 残留的 socket 文件被删除
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	old := syscall.Umask(0022)
+	defer syscall.Umask(old)
+
+	ln, err := ListenUnix(&UnixSocket{Path: path, Mode: 0660})
+	if err != nil {
+		t.Fatalf("listen on stale socket: %v", err)
+	}
+	defer ln.Close()
+
+	if mask := syscall.Umask(0022); mask != 0022 {
+		t.Errorf("umask changed, got %#o", mask)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0660 {
+		t.Errorf("want mode 0660, got %#o", perm)
+	}
+	// 临时目录已删除
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("want only the socket in %s, got %v (%v)", filepath.Dir(path), entries, err)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dial renamed socket: %v", err)
+	}
+	conn.Close()
+
+	// 仍在监听的 socket 不被删除
+	if _, err := ListenUnix(&UnixSocket{Path: path}); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("want in use error, got %v", err)
+	}
+
+	// 不是 socket 的文件不被删除
+	regular := filepath.Join(t.TempDir(), "regular")
+	if err := os.WriteFile(regular, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenUnix(&UnixSocket{Path: regular}); err == nil {
+		t.Error("want error for regular file")
+	}
+	if _, err := os.Stat(regular); err != nil {
+		t.Errorf("regular file removed: %v", err)
+	}
+}
+
+func TestListenUnixOwnerDefaultMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sshd.sock")
+	ln, err := ListenUnix(&UnixSocket{Path: path, Owner: strconv.Itoa(os.Getuid())})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0600 {
+		t.Errorf("want mode 0600, got %#o", perm)
+	}
+
+	// 关闭时删除 socket 文件
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket not removed on close: %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package sshd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrUserNotFound = errors.New("sshd: user not found")
+	ErrUserDisabled = errors.New("sshd: user disabled")
+)
+
+// User UserStore 中的用户
+type User struct {
+	Name string `json:"name" yaml:"name"`
+	ID   string `json:"id,omitempty" yaml:"id,omitempty"`
+
+	// Keys authorized_keys 格式的公钥, 可带选项, 如 `no-pty ssh-ed25519 AAAA...`
+	Keys   []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	Groups []string `json:"groups,omitempty" yaml:"groups,omitempty"`
+
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+	// PasswordHash bcrypt 或 argon2id 的密码哈希, 见 VerifyPasswordHash.
+	PasswordHash string `json:"password_hash,omitempty" yaml:"password_hash,omitempty"`
+}
+
+// UserStore 用户与公钥的存储, 用户不存在时应返回 ErrUserNotFound.
+type UserStore interface {
+	LookupUser(ctx context.Context, name string) (*User, error)
+}
+
+// MemoryUserStore 内存中的 UserStore
+type MemoryUserStore struct {
+	mut   sync.RWMutex
+	users map[string]*User
+}
+
+var _ UserStore = &MemoryUserStore{}
+
+func NewMemoryUserStore(users ...*User) *MemoryUserStore {
+	ms := &MemoryUserStore{users: make(map[string]*User, len(users))}
+	for _, u := range users {
+		ms.users[u.Name] = u
+	}
+	return ms
+}
+
+// LookupUser implements UserStore
+func (ms *MemoryUserStore) LookupUser(_ context.Context, name string) (*User, error) {
+	ms.mut.RLock()
+	defer ms.mut.RUnlock()
+
+	u, ok := ms.users[name]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return u, nil
+}
+
+// Put 添加或替换用户
+func (ms *MemoryUserStore) Put(u *User) {
+	ms.mut.Lock()
+	defer ms.mut.Unlock()
+	if ms.users == nil {
+		ms.users = make(map[string]*User)
+	}
+	ms.users[u.Name] = u
+}
+
+// Delete 删除用户
+func (ms *MemoryUserStore) Delete(name string) {
+	ms.mut.Lock()
+	defer ms.mut.Unlock()
+	delete(ms.users, name)
+}
+
+// Replace 原子地替换全部用户
+func (ms *MemoryUserStore) Replace(users []*User) {
+	m := make(map[string]*User, len(users))
+	for _, u := range users {
+		m[u.Name] = u
+	}
+
+	ms.mut.Lock()
+	ms.users = m
+	ms.mut.Unlock()
+}
+
+// FileUserStore 从 JSON 或 YAML 文件 (根据扩展名 .yaml, .yml 区分) 读取用户列表.
+// 文件内容为 User 数组, 或带 users 字段的对象.
+// 每次查询前检查文件是否变化, 变化后重新加载并原子地替换全部用户.
+type FileUserStore struct {
+	// ErrLogger 输出重新加载时的错误, 默认为 log.Default, 可设置为 Server.ErrLogger
+	ErrLogger *log.Logger
+
+	reloader *fileReloader
+	mem      *MemoryUserStore
+}
+
+var _ UserStore = &FileUserStore{}
+
+// NewFileUserStore 读取并解析用户文件
+func NewFileUserStore(filename string) (*FileUserStore, error) {
+	fs := &FileUserStore{mem: NewMemoryUserStore()}
+	fs.reloader = newFileReloader("user file", filename, fs.load)
+	if err := fs.Reload(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// LookupUser implements UserStore
+func (fs *FileUserStore) LookupUser(ctx context.Context, name string) (*User, error) {
+	fs.reloader.reloadIfChanged(fs.ErrLogger)
+	return fs.mem.LookupUser(ctx, name)
+}
+
+// Reload 重新读取用户文件
+func (fs *FileUserStore) Reload() error {
+	return fs.reloader.reload()
+}
+
+func (fs *FileUserStore) load(data []byte) error {
+	users, err := parseUserFile(fs.reloader.filename, data)
+	if err != nil {
+		return err
+	}
+	fs.mem.Replace(users)
+	return nil
+}
+
+func parseUserFile(filename string, data []byte) ([]*User, error) {
+	unmarshal := json.Unmarshal
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		unmarshal = yaml.Unmarshal
+	}
+
+	var users []*User
+	if err := unmarshal(data, &users); err != nil {
+		var wrapper struct {
+			Users []*User `json:"users" yaml:"users"`
+		}
+		if err2 := unmarshal(data, &wrapper); err2 != nil {
+			return nil, err
+		}
+		users = wrapper.Users
+	}
+
+	for i, u := range users {
+		if u == nil || len(u.Name) == 0 {
+			return nil, fmt.Errorf("user #%d has no name", i)
+		}
+		if err := checkPasswordHash(u.PasswordHash); err != nil {
+			return nil, fmt.Errorf("user %s: %w", u.Name, err)
+		}
+		for _, line := range u.Keys {
+			if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+				return nil, fmt.Errorf("user %s: invalid key %q: %w", u.Name, line, err)
+			}
+		}
+	}
+	return users, nil
+}
+
+// UserStoreAuth 基于 UserStore 的 Authenticator, 支持公钥, 密码与交互式密码认证.
+// 认证通过后附加以 User 创建的 Principal.
+type UserStoreAuth struct {
+	Store UserStore
+}
+
+var (
+	_ Authenticator     = &UserStoreAuth{}
+	_ AuthMethodChecker = &UserStoreAuth{}
+)
+
+func NewUserStoreAuth(store UserStore) *UserStoreAuth {
+	return &UserStoreAuth{Store: store}
+}
+
+// SupportsAuthMethod implements AuthMethodChecker
+func (ua *UserStoreAuth) SupportsAuthMethod(method string) bool {
+	switch method {
+	case AuthMethodPublicKey, AuthMethodPassword, AuthMethodKeyboardInteractive:
+		return true
+	}
+	return false
+}
+
+// PublicKey implements Authenticator.PublicKey
+func (ua *UserStoreAuth) PublicKey(ctx context.Context, conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	u, err := ua.lookup(ctx, conn.User())
+	if err != nil {
+		return nil, err
+	}
+
+	perms, err := AuthorizedKeysPermissions([]byte(strings.Join(u.Keys, "\n")), key, addrIP(conn.RemoteAddr()))
+	if err != nil {
+		return nil, err
+	}
+	return perms, userPrincipal(u).Attach(perms)
+}
+
+// Password implements Authenticator.Password
+func (ua *UserStoreAuth) Password(ctx context.Context, conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	u, err := ua.lookup(ctx, conn.User())
+	if err != nil {
+		// 与存在的用户耗时一致
+		_ = bcrypt.CompareHashAndPassword(dummyBcryptHash, password)
+		return nil, err
+	}
+	if len(u.PasswordHash) == 0 {
+		return nil, ErrAuthNotSupported
+	}
+	if err := VerifyPasswordHash(u.PasswordHash, password); err != nil {
+		return nil, err
+	}
+	return userPrincipal(u).Permissions()
+}
+
+// KeyboardInteractive implements Authenticator.KeyboardInteractive, 以交互方式询问密码.
+func (ua *UserStoreAuth) KeyboardInteractive(ctx context.Context, conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	answers, err := client(conn.User(), "", []string{"Password: "}, []bool{false})
+	if err != nil {
+		return nil, err
+	}
+	if len(answers) != 1 {
+		return nil, errors.New("sshd: unexpected number of answers")
+	}
+	return ua.Password(ctx, conn, []byte(answers[0]))
+}
+
+// Groups 适配 AccessList.Groups
+func (ua *UserStoreAuth) Groups(ctx context.Context, name string) ([]string, error) {
+	u, err := ua.Store.LookupUser(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return u.Groups, nil
+}
+
+func (ua *UserStoreAuth) lookup(ctx context.Context, name string) (*User, error) {
+	if ua.Store == nil {
+		return nil, ErrAuthNotSupported
+	}
+	u, err := ua.Store.LookupUser(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if u.Disabled {
+		return nil, fmt.Errorf("%w: %s", ErrUserDisabled, name)
+	}
+	return u, nil
+}
+
+func userPrincipal(u *User) *Principal {
+	return &Principal{
+		UserID: u.ID,
+		User:   u.Name,
+		Groups: u.Groups,
+	}
+}
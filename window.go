@@ -0,0 +1,261 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const DefaultAccessWindowMessage = "sshd: your access window has closed"
+
+var ErrOutsideAccessWindow = errors.New("sshd: outside access window")
+
+// AccessWindow 允许登录的时间段, 如工作日的 09:00-18:00.
+// Start 大于 End 时跨越午夜, 如 22:00-06:00, 此时 Days 指开始的那一天.
+type AccessWindow struct {
+	// Days 允许的星期, 为空时为每天
+	Days []time.Weekday
+	// Start, End 距离当天 00:00 的时长
+	Start time.Duration
+	End   time.Duration
+	// Location 时区, 为 nil 时为 time.Local
+	Location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseAccessWindow 解析形如 "Mon-Fri 09:00-18:00 Asia/Shanghai" 的时间段,
+// 星期可以是以逗号分隔的列表或范围, 如 "Mon,Wed,Fri", "Sat-Sun", "*"; 时区可省略.
+func ParseAccessWindow(s string) (AccessWindow, error) {
+	var w AccessWindow
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 {
+		return w, fmt.Errorf("sshd: invalid access window %q", s)
+	}
+
+	days, err := parseWeekdays(fields[0])
+	if err != nil {
+		return w, err
+	}
+	w.Days = days
+
+	start, end, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return w, fmt.Errorf("sshd: invalid access window time range %q", fields[1])
+	}
+	if w.Start, err = parseClock(start); err != nil {
+		return w, err
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return w, err
+	}
+
+	if len(fields) == 3 {
+		if w.Location, err = time.LoadLocation(fields[2]); err != nil {
+			return w, err
+		}
+	}
+	return w, nil
+}
+
+// MustParseAccessWindows 解析多个时间段, 出错时 panic
+func MustParseAccessWindows(specs ...string) []AccessWindow {
+	windows := make([]AccessWindow, 0, len(specs))
+	for _, spec := range specs {
+		w, err := ParseAccessWindow(spec)
+		if err != nil {
+			panic(err)
+		}
+		windows = append(windows, w)
+	}
+	return windows
+}
+
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	if s == "*" {
+		return nil, nil
+	}
+
+	var days []time.Weekday
+	for _, item := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(strings.ToLower(item), "-")
+		start, ok := weekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("sshd: invalid weekday %q", from)
+		}
+		if !isRange {
+			days = append(days, start)
+			continue
+		}
+		end, ok := weekdays[to]
+		if !ok {
+			return nil, fmt.Errorf("sshd: invalid weekday %q", to)
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == end {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("sshd: invalid clock %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// activeUntil 若 t 在时间段内, 返回时间段结束的时间
+func (w AccessWindow) activeUntil(t time.Time) (time.Time, bool) {
+	loc := w.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+
+	// 检查今天开始的时间段, 以及昨天开始并跨越午夜的时间段
+	for _, offset := range []int{0, -1} {
+		day := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, 0, 0, 0, loc)
+		if !w.allowsDay(day.Weekday()) {
+			continue
+		}
+
+		// 以墙上时间构造, 夏令时切换当天的一天不是 24 小时
+		start := w.clock(day, w.Start)
+		end := w.clock(day, w.End)
+		if w.End <= w.Start {
+			end = w.clock(day.AddDate(0, 0, 1), w.End)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// clock 返回 day 当天 d 对应的墙上时间
+func (w AccessWindow) clock(day time.Time, d time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		int(d/time.Hour), int(d%time.Hour/time.Minute), 0, 0, day.Location())
+}
+
+func (w AccessWindow) allowsDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessSchedule 按用户或组限制登录的时间段, 在认证时检查,
+// 已建立的连接在时间段结束时被断开, 并向 session 写入 Message.
+// 用户及其所在的组均未配置时间段时不受限制.
+type AccessSchedule struct {
+	Users  map[string][]AccessWindow
+	Groups map[string][]AccessWindow
+
+	// GroupsFunc 查询用户所属的组, Groups 非空时必须设置.
+	GroupsFunc GroupsFunc
+
+	// Message 断开连接时写入 session 的 stderr, 为空时为 DefaultAccessWindowMessage
+	Message string
+}
+
+// Check 检查 user 当前是否允许登录. 受限制时返回当前时间段结束的时间, 不受限制时返回零值.
+// 不在时间段内时返回的错误包装了 ErrOutsideAccessWindow.
+func (as *AccessSchedule) Check(ctx context.Context, user string, now time.Time) (time.Time, error) {
+	windows := as.Users[user]
+	if len(as.Groups) > 0 {
+		if as.GroupsFunc == nil {
+			return time.Time{}, fmt.Errorf("%w: no groups func for user %s", ErrOutsideAccessWindow, user)
+		}
+		groups, err := as.GroupsFunc(ctx, user)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: lookup groups of user %s: %v", ErrOutsideAccessWindow, user, err)
+		}
+		for _, group := range groups {
+			windows = append(windows, as.Groups[group]...)
+		}
+	}
+	if len(windows) == 0 {
+		return time.Time{}, nil
+	}
+
+	var until time.Time
+	for _, w := range windows {
+		if end, ok := w.activeUntil(now); ok && end.After(until) {
+			until = end
+		}
+	}
+	if until.IsZero() {
+		return time.Time{}, fmt.Errorf("%w: user %s at %s", ErrOutsideAccessWindow, user, now.Format(time.RFC3339))
+	}
+	return until, nil
+}
+
+func (as *AccessSchedule) message() string {
+	if len(as.Message) > 0 {
+		return as.Message
+	}
+	return DefaultAccessWindowMessage
+}
+
+// enforceAccessSchedule 在时间段结束时断开连接, 若紧接着另一个时间段则继续等待.
+// 仅不受限制的用户跳过, Check 返回错误 (如查询组失败) 时立即断开连接.
+func (sc *serverConn) enforceAccessSchedule(as *AccessSchedule) {
+	user := sc.conn.User()
+	until, err := as.Check(sc.ctx, user, time.Now())
+	if err != nil {
+		sc.srv.logf("%v, disconnecting %s", err, sc.conn.RemoteAddr())
+		_ = sc.closeWithMessage(as.message())
+		return
+	}
+	if until.IsZero() {
+		return
+	}
+
+	var timer *time.Timer
+	var check func()
+	check = func() {
+		until, err := as.Check(sc.ctx, user, time.Now())
+		if err == nil && until.IsZero() {
+			// 配置变化后不再受限制
+			return
+		}
+		if err == nil {
+			sc.mut.Lock()
+			if !sc.closed {
+				timer = time.AfterFunc(time.Until(until), check)
+			}
+			sc.mut.Unlock()
+			return
+		}
+		sc.srv.logf("sshd: access window of %s closed, disconnecting %s", user, sc.conn.RemoteAddr())
+		_ = sc.closeWithMessage(as.message())
+	}
+
+	sc.mut.Lock()
+	timer = time.AfterFunc(time.Until(until), check)
+	sc.mut.Unlock()
+
+	go func() {
+		_ = sc.conn.Wait()
+		sc.mut.Lock()
+		sc.closed = true
+		timer.Stop()
+		sc.mut.Unlock()
+	}()
+}
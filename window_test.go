@@ -0,0 +1,108 @@
+package sshd
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAccessWindowDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+
+	cases := []struct {
+		spec    string
+		now     time.Time
+		active  bool
+		wantEnd time.Time
+	}{
+		// 2026-03-08 02:00 开始夏令时, 当天只有 23 小时
+		{"* 09:00-18:00 America/New_York", time.Date(2026, 3, 8, 9, 30, 0, 0, loc), true, time.Date(2026, 3, 8, 18, 0, 0, 0, loc)},
+		{"* 09:00-18:00 America/New_York", time.Date(2026, 3, 8, 8, 30, 0, 0, loc), false, time.Time{}},
+		{"* 09:00-18:00 America/New_York", time.Date(2026, 3, 8, 18, 30, 0, 0, loc), false, time.Time{}},
+		// 2026-11-01 02:00 结束夏令时, 当天有 25 小时
+		{"* 09:00-18:00 America/New_York", time.Date(2026, 11, 1, 8, 30, 0, 0, loc), false, time.Time{}},
+		{"* 09:00-18:00 America/New_York", time.Date(2026, 11, 1, 17, 30, 0, 0, loc), true, time.Date(2026, 11, 1, 18, 0, 0, 0, loc)},
+		// 跨越午夜与夏令时切换
+		{"Sat 22:00-06:00 America/New_York", time.Date(2026, 11, 1, 5, 30, 0, 0, loc), true, time.Date(2026, 11, 1, 6, 0, 0, 0, loc)},
+		{"Sat 22:00-06:00 America/New_York", time.Date(2026, 11, 1, 6, 30, 0, 0, loc), false, time.Time{}},
+	}
+	for _, c := range cases {
+		w, err := ParseAccessWindow(c.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		end, ok := w.activeUntil(c.now)
+		if ok != c.active || !end.Equal(c.wantEnd) {
+			t.Errorf("%s at %s: got (%s, %v), want (%s, %v)", c.spec, c.now, end, ok, c.wantEnd, c.active)
+		}
+	}
+}
+
+func TestAccessScheduleCheck(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC) // 星期三
+	always := MustParseAccessWindows("* 00:00-00:00 UTC")
+	never := MustParseAccessWindows("Sun 00:00-01:00 UTC")
+	groups := func(ctx context.Context, user string) ([]string, error) {
+		if user == "broken" {
+			return nil, errors.New("directory unavailable")
+		}
+		return []string{"ops"}, nil
+	}
+
+	cases := []struct {
+		name       string
+		schedule   AccessSchedule
+		user       string
+		restricted bool
+		wantErr    bool
+	}{
+		{"unrestricted", AccessSchedule{Users: map[string][]AccessWindow{"bob": never}}, "alice", false, false},
+		{"user inside window", AccessSchedule{Users: map[string][]AccessWindow{"alice": always}}, "alice", true, false},
+		{"user outside window", AccessSchedule{Users: map[string][]AccessWindow{"alice": never}}, "alice", false, true},
+		{"group inside window", AccessSchedule{Groups: map[string][]AccessWindow{"ops": always}, GroupsFunc: groups}, "alice", true, false},
+		{"group outside window", AccessSchedule{Groups: map[string][]AccessWindow{"ops": never}, GroupsFunc: groups}, "alice", false, true},
+		{"groups lookup error", AccessSchedule{Groups: map[string][]AccessWindow{"ops": always}, GroupsFunc: groups}, "broken", false, true},
+		{"nil groups func", AccessSchedule{Groups: map[string][]AccessWindow{"ops": always}}, "alice", false, true},
+	}
+	for _, c := range cases {
+		until, err := c.schedule.Check(context.Background(), c.user, now)
+		if c.wantErr != (err != nil) {
+			t.Errorf("%s: got error %v, want error %v", c.name, err, c.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrOutsideAccessWindow) {
+			t.Errorf("%s: error %v does not wrap ErrOutsideAccessWindow", c.name, err)
+		}
+		if c.restricted == until.IsZero() {
+			t.Errorf("%s: got until %s, want restricted %v", c.name, until, c.restricted)
+		}
+	}
+}
+
+func TestAccessScheduleEnforceError(t *testing.T) {
+	// 认证时查询组成功, 之后失败
+	var lookups atomic.Int32
+	as := &AccessSchedule{
+		Groups: map[string][]AccessWindow{"ops": MustParseAccessWindows("* 00:00-00:00 UTC")},
+		GroupsFunc: func(ctx context.Context, user string) ([]string, error) {
+			if lookups.Add(1) > 1 {
+				return nil, errors.New("directory unavailable")
+			}
+			return []string{"ops"}, nil
+		},
+	}
+	_, addr := newTestServer(t, nil, WithAccessSchedule(as))
+	client := dialTestServer(t, addr)
+
+	done := make(chan error, 1)
+	go func() { done <- client.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 2):
+		t.Fatal("connection not closed when the access schedule check failed")
+	}
+}